@@ -0,0 +1,365 @@
+// Command testingdock manages testingdock environments outside of go test,
+// e.g. to bring up the environment of the tests for debugging.
+//
+// Usage:
+//  testingdock [flags] <command> [arguments]
+//
+// The commands are:
+//  up -f <definition> [-d]          start the suite, until interrupted or detached with -d
+//  down -f <definition> | -suite <name>
+//                                   remove the suite and everything left by previous runs
+//  ps [-suite <name>]               list the containers started by testingdock
+//  logs [-follow] [-tail <n>] <container>
+//                                   print the logs of a container
+//  reset -f <definition>            reset the containers of a suite started with up -d
+//  prune [-suite <name>] [-images]  remove all resources labeled owner=testingdock
+//
+// The definition is a declarative suite definition, see testingdock.Definition.
+// The flags are the flags of the testingdock package, e.g. -testingdock.verbose.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/piotrkowalczuk/testingdock"
+)
+
+var commands = map[string]func(ctx context.Context, cli *client.Client, args []string) error{
+	"up":    up,
+	"down":  down,
+	"ps":    ps,
+	"logs":  logs,
+	"reset": reset,
+	"prune": prune,
+}
+
+// errUsage is returned by run, if the command is missing or unknown.
+var errUsage = errors.New("command required")
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	switch err := run(context.Background(), flag.Args()); err {
+	case nil:
+	case errUsage:
+		usage()
+		os.Exit(2)
+	case flag.ErrHelp:
+		// the usage of the command is printed by its flag set
+	default:
+		fatalf("%s", err.Error())
+	}
+}
+
+// run runs the command given by the first argument with the remaining ones.
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "testingdock: unknown command %s\n", args[0]) // nolint: errcheck
+		return errUsage
+	}
+
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		return fmt.Errorf("docker client instantiation failure: %s", err.Error())
+	}
+	return cmd(ctx, cli, args[1:])
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: testingdock [flags] up|down|ps|logs|reset|prune [arguments]") // nolint: errcheck
+	flag.PrintDefaults()
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "testingdock: "+format+"\n", args...) // nolint: errcheck
+	os.Exit(1)
+}
+
+// cliT implements testing.TB for suites outside of go test, a fatal failure
+// or a skip terminates the command, after running the cleanup functions. The
+// resources started so far are left behind and are removed by down or prune.
+type cliT struct {
+	// nil, it only provides the unexported method of testing.TB, all other
+	// methods are implemented below
+	testing.TB
+
+	mu       sync.Mutex
+	failed   bool
+	skipped  bool
+	cleanups []func()
+}
+
+func (t *cliT) Error(args ...interface{})                 { t.Errorf("%s", fmt.Sprint(args...)) }
+func (t *cliT) Fatal(args ...interface{})                 { t.Fatalf("%s", fmt.Sprint(args...)) }
+func (t *cliT) Log(args ...interface{})                   { t.Logf("%s", fmt.Sprint(args...)) }
+func (t *cliT) Skip(args ...interface{})                  { t.Skipf("%s", fmt.Sprint(args...)) }
+func (t *cliT) Helper()                                   {}
+func (t *cliT) Name() string                              { return "testingdock" }
+func (t *cliT) Setenv(key, value string)                  { os.Setenv(key, value) } // nolint: errcheck
+func (t *cliT) Logf(format string, args ...interface{})   { fmt.Printf(format+"\n", args...) }
+func (t *cliT) Fatalf(format string, args ...interface{}) { t.Errorf(format, args...); t.FailNow() }
+
+func (t *cliT) Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "testingdock: "+format+"\n", args...) // nolint: errcheck
+	t.Fail()
+}
+
+func (t *cliT) Skipf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "testingdock: skipped: "+format+"\n", args...) // nolint: errcheck
+	t.SkipNow()
+}
+
+func (t *cliT) Fail() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failed = true
+}
+
+func (t *cliT) Failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failed
+}
+
+func (t *cliT) Skipped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.skipped
+}
+
+// FailNow exits, it may be called from any goroutine, unlike in go test.
+func (t *cliT) FailNow() {
+	t.Fail()
+	t.cleanup()
+	os.Exit(1)
+}
+
+// SkipNow exits like FailNow, the command can not continue either.
+func (t *cliT) SkipNow() {
+	t.mu.Lock()
+	t.skipped = true
+	t.mu.Unlock()
+	t.cleanup()
+	os.Exit(1)
+}
+
+func (t *cliT) TempDir() string {
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatalf("temporary directory creation failure: %s", err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) }) // nolint: errcheck
+	return dir
+}
+
+func (t *cliT) Cleanup(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cleanups = append(t.cleanups, f)
+}
+
+// cleanup runs the cleanup functions in the reverse order of their registration.
+func (t *cliT) cleanup() {
+	t.mu.Lock()
+	cleanups := t.cleanups
+	t.cleanups = nil
+	t.mu.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+}
+
+// loadSuite loads the suite of the definition file. All containers are
+// reusable, so later commands adopt them instead of recreating them.
+func loadSuite(t *cliT, cli *client.Client, path string, keep testingdock.KeepMode) (*testingdock.Suite, error) {
+	d, err := testingdock.LoadDefinition(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := range d.Containers {
+		d.Containers[i].Reuse = true
+	}
+	s, _ := d.Suite(t, testingdock.SuiteOpts{Client: cli, Keep: keep})
+	return s, nil
+}
+
+func up(ctx context.Context, cli *client.Client, args []string) error {
+	fs := flag.NewFlagSet("up", flag.ContinueOnError)
+	path := fs.String("f", "", "suite definition file")
+	detach := fs.Bool("d", false, "detach, keep the suite running after the command exited")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("up: suite definition file required")
+	}
+
+	t := &cliT{}
+	defer t.cleanup()
+	s, err := loadSuite(t, cli, *path, testingdock.KeepAlways)
+	if err != nil {
+		return err
+	}
+	s.Start(ctx)
+	if err = s.Close(); err != nil {
+		return err
+	}
+	if err = printContainers(ctx, cli, s.Name()); err != nil {
+		return err
+	}
+	if *detach {
+		return nil
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	fmt.Println("suite is running, press ctrl+c to remove it")
+	<-sig
+	s.Down(ctx)
+	return nil
+}
+
+func down(ctx context.Context, cli *client.Client, args []string) error {
+	fs := flag.NewFlagSet("down", flag.ContinueOnError)
+	path := fs.String("f", "", "suite definition file")
+	suite := fs.String("suite", "", "name of the suite, if there is no definition")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case *path != "":
+		t := &cliT{}
+		defer t.cleanup()
+		s, err := loadSuite(t, cli, *path, testingdock.KeepNever)
+		if err != nil {
+			return err
+		}
+		s.Down(ctx)
+		return nil
+	case *suite != "":
+		return testingdock.Prune(ctx, cli, testingdock.PruneOpts{Suite: *suite})
+	}
+	return fmt.Errorf("down: suite definition file or suite name required")
+}
+
+func ps(ctx context.Context, cli *client.Client, args []string) error {
+	fs := flag.NewFlagSet("ps", flag.ContinueOnError)
+	suite := fs.String("suite", "", "name of the suite")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	return printContainers(ctx, cli, *suite)
+}
+
+// printContainers prints a table of the containers started by testingdock.
+func printContainers(ctx context.Context, cli *client.Client, suite string) error {
+	containers, err := testingdock.ListContainers(ctx, cli, suite)
+	if err != nil {
+		return err
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Names[0] < containers[j].Names[0] })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSUITE\tIMAGE\tSTATUS\tPORTS") // nolint: errcheck
+	for _, c := range containers {
+		var ports []string
+		for _, p := range c.Ports {
+			if p.PublicPort != 0 {
+				ports = append(ports, fmt.Sprintf("%s:%d->%d/%s", p.IP, p.PublicPort, p.PrivatePort, p.Type))
+			}
+		}
+		sort.Strings(ports)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", // nolint: errcheck
+			strings.TrimPrefix(c.Names[0], "/"), c.Labels[testingdock.SuiteLabel], c.Image, c.Status, strings.Join(ports, ", "))
+	}
+	return w.Flush()
+}
+
+func logs(ctx context.Context, cli *client.Client, args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	follow := fs.Bool("follow", false, "follow the log output")
+	tail := fs.String("tail", "all", "number of lines to show from the end of the logs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("logs: container name required")
+	}
+
+	cjson, err := cli.ContainerInspect(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	reader, err := cli.ContainerLogs(ctx, cjson.ID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     *follow,
+		Tail:       *tail,
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close() // nolint: errcheck
+
+	// without a TTY docker multiplexes stdout and stderr into one stream
+	if cjson.Config.Tty {
+		_, err = io.Copy(os.Stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, reader)
+	}
+	return err
+}
+
+func reset(ctx context.Context, cli *client.Client, args []string) error {
+	fs := flag.NewFlagSet("reset", flag.ContinueOnError)
+	path := fs.String("f", "", "suite definition file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("reset: suite definition file required")
+	}
+
+	// adopting the running containers runs their ResetFunc and health check
+	t := &cliT{}
+	defer t.cleanup()
+	s, err := loadSuite(t, cli, *path, testingdock.KeepAlways)
+	if err != nil {
+		return err
+	}
+	s.Start(ctx)
+	return s.Close()
+}
+
+func prune(ctx context.Context, cli *client.Client, args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	suite := fs.String("suite", "", "name of the suite, the default is all suites")
+	images := fs.Bool("images", false, "remove the images built by testingdock as well")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	return testingdock.Prune(ctx, cli, testingdock.PruneOpts{Suite: *suite, Images: *images})
+}
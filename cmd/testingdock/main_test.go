@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/client"
+	"github.com/piotrkowalczuk/testingdock"
+)
+
+func TestRun_arguments(t *testing.T) {
+	for name, given := range map[string]struct {
+		args []string
+		err  string
+	}{
+		"no command":         {args: nil, err: errUsage.Error()},
+		"unknown command":    {args: []string{"start"}, err: errUsage.Error()},
+		"unknown flag":       {args: []string{"up", "-x"}, err: "flag provided but not defined: -x"},
+		"up without file":    {args: []string{"up", "-d"}, err: "up: suite definition file required"},
+		"up missing file":    {args: []string{"up", "-f", "testdata/missing.yaml"}, err: "no such file or directory"},
+		"down without file":  {args: []string{"down"}, err: "down: suite definition file or suite name required"},
+		"reset without file": {args: []string{"reset"}, err: "reset: suite definition file required"},
+		"logs without name":  {args: []string{"logs", "-tail", "10"}, err: "logs: container name required"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := run(context.TODO(), given.args)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), given.err) {
+				t.Errorf("wrong error, expected %q but got: %s", given.err, err)
+			}
+		})
+	}
+}
+
+func TestRun_upDown(t *testing.T) {
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err = run(context.TODO(), []string{"up", "-d", "-f", "testdata/suite.yaml"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	containers, err := testingdock.ListContainers(context.TODO(), cli, "TestRun_upDown")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(containers) != 1 || containers[0].State != "running" {
+		t.Fatalf("expected the detached container to be running, got: %v", containers)
+	}
+	if containers[0].Labels[testingdock.SuiteLabel] != "TestRun_upDown" {
+		t.Errorf("wrong suite label, expected TestRun_upDown but got %s", containers[0].Labels[testingdock.SuiteLabel])
+	}
+
+	if err = run(context.TODO(), []string{"down", "-f", "testdata/suite.yaml"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if containers, err = testingdock.ListContainers(context.TODO(), cli, "TestRun_upDown"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(containers) != 0 {
+		t.Errorf("expected the containers to be removed, got: %v", containers)
+	}
+}
@@ -0,0 +1,880 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"gopkg.in/yaml.v3"
+)
+
+// Conditions of a compose depends_on entry. Testingdock starts a container
+// after its dependencies are healthy in every case, a dependency with
+// ComposeServiceCompletedSuccessfully is started as job, see ContainerOpts.
+const (
+	ComposeServiceStarted               = "service_started"
+	ComposeServiceHealthy               = "service_healthy"
+	ComposeServiceCompletedSuccessfully = "service_completed_successfully"
+)
+
+// ComposeOpts is an option struct for importing a docker compose file in
+// Suite.Compose.
+type ComposeOpts struct {
+	// Path of the compose file, relative build contexts and bind mounts
+	// are resolved relative to its directory
+	Path string
+	// Network all services are started in, if the suite has no network
+	// yet, the default is the suite name. The networks of the compose file
+	// only contribute the aliases.
+	Network string
+	// Override is called with the options of every service before its
+	// container is created, e.g. to attach a Go health check or ResetFunc.
+	Override func(service string, opts *ContainerOpts)
+}
+
+// ComposeFile is the part of a docker compose file, which is imported by
+// Suite.Compose. Other keys are ignored.
+type ComposeFile struct {
+	Services map[string]*ComposeService
+	// External volumes are not managed by testingdock
+	ExternalVolumes map[string]bool
+}
+
+// ComposeService is a service of a docker compose file, the short and long
+// syntax of the keys are normalized.
+type ComposeService struct {
+	Image         string
+	Build         *ComposeBuild
+	ContainerName string
+	Command       []string
+	Entrypoint    []string
+	// Environment as KEY=VALUE, sorted by key
+	Environment []string
+	// Ports in the format of docker run -p, e.g. 127.0.0.1:8080:80/tcp
+	Ports   []string
+	Volumes []ComposeMount
+	Tmpfs   []string
+	// DependsOn maps the dependencies to their condition
+	DependsOn   map[string]string
+	Healthcheck *container.HealthConfig
+	// Networks maps the networks to the aliases of the service
+	Networks   map[string][]string
+	WorkingDir string
+	User       string
+	Privileged bool
+}
+
+// ComposeBuild is the build configuration of a compose service.
+type ComposeBuild struct {
+	Context    string
+	Dockerfile string
+	Args       map[string]*string
+}
+
+// ComposeMount is a volume of a compose service.
+type ComposeMount struct {
+	// Type is either volume, bind or tmpfs
+	Type     string
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// composeFileNode is a compose file as decoded by yaml, keys with short
+// and long syntax are kept as nodes and normalized afterwards.
+type composeFileNode struct {
+	Services map[string]composeServiceNode `yaml:"services"`
+	Volumes  map[string]*struct {
+		External bool `yaml:"external"`
+	} `yaml:"volumes"`
+}
+
+type composeServiceNode struct {
+	Image         string      `yaml:"image"`
+	Build         yaml.Node   `yaml:"build"`
+	ContainerName string      `yaml:"container_name"`
+	Command       yaml.Node   `yaml:"command"`
+	Entrypoint    yaml.Node   `yaml:"entrypoint"`
+	Environment   yaml.Node   `yaml:"environment"`
+	Ports         []yaml.Node `yaml:"ports"`
+	Volumes       []yaml.Node `yaml:"volumes"`
+	Tmpfs         yaml.Node   `yaml:"tmpfs"`
+	DependsOn     yaml.Node   `yaml:"depends_on"`
+	Healthcheck   yaml.Node   `yaml:"healthcheck"`
+	Networks      yaml.Node   `yaml:"networks"`
+	WorkingDir    string      `yaml:"working_dir"`
+	User          string      `yaml:"user"`
+	Privileged    bool        `yaml:"privileged"`
+}
+
+// ParseCompose parses a docker compose file. Variables like ${VAR} and
+// ${VAR:-default} are substituted from the environment. Errors contain the
+// line of the invalid value.
+func ParseCompose(r io.Reader) (*ComposeFile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw composeFileNode
+	if err = yaml.Unmarshal([]byte(interpolate(string(data))), &raw); err != nil {
+		return nil, err
+	}
+	if len(raw.Services) == 0 {
+		return nil, fmt.Errorf("compose file has no services")
+	}
+
+	file := &ComposeFile{
+		Services:        make(map[string]*ComposeService, len(raw.Services)),
+		ExternalVolumes: make(map[string]bool),
+	}
+	for name, v := range raw.Volumes {
+		if v != nil && v.External {
+			file.ExternalVolumes[name] = true
+		}
+	}
+	for _, name := range sortedKeys(raw.Services) {
+		svc, err := raw.Services[name].normalize()
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %s", name, err)
+		}
+		file.Services[name] = svc
+	}
+	for _, name := range sortedKeys(raw.Services) {
+		for dep := range file.Services[name].DependsOn {
+			if _, ok := file.Services[dep]; !ok {
+				n := raw.Services[name].DependsOn
+				return nil, fmt.Errorf("line %d: service %s depends on undefined service %s", n.Line, name, dep)
+			}
+		}
+	}
+	return file, nil
+}
+
+// normalize converts the short and long syntax of the service keys.
+func (n composeServiceNode) normalize() (*ComposeService, error) { // nolint: gocyclo
+	svc := &ComposeService{
+		Image:         n.Image,
+		ContainerName: n.ContainerName,
+		WorkingDir:    n.WorkingDir,
+		User:          n.User,
+		Privileged:    n.Privileged,
+	}
+
+	var err error
+	if svc.Build, err = composeBuild(&n.Build); err != nil {
+		return nil, err
+	}
+	if svc.Image == "" && svc.Build == nil {
+		return nil, fmt.Errorf("neither image nor build set")
+	}
+	if svc.Command, err = composeCommand(&n.Command); err != nil {
+		return nil, err
+	}
+	if svc.Entrypoint, err = composeCommand(&n.Entrypoint); err != nil {
+		return nil, err
+	}
+	env, err := composeMapping(&n.Environment)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range sortedKeys(env) {
+		if env[key] != nil {
+			svc.Environment = append(svc.Environment, key+"="+*env[key])
+		} else if val, ok := os.LookupEnv(key); ok {
+			svc.Environment = append(svc.Environment, key+"="+val)
+		}
+	}
+	for i := range n.Ports {
+		port, err := composePort(&n.Ports[i])
+		if err != nil {
+			return nil, err
+		}
+		svc.Ports = append(svc.Ports, port)
+	}
+	for i := range n.Volumes {
+		m, err := composeMount(&n.Volumes[i])
+		if err != nil {
+			return nil, err
+		}
+		svc.Volumes = append(svc.Volumes, m)
+	}
+	if svc.Tmpfs, err = composeList(&n.Tmpfs); err != nil {
+		return nil, err
+	}
+	if svc.DependsOn, err = composeDependsOn(&n.DependsOn); err != nil {
+		return nil, err
+	}
+	if svc.Healthcheck, err = composeHealthcheck(&n.Healthcheck); err != nil {
+		return nil, err
+	}
+	if svc.Networks, err = composeNetworks(&n.Networks); err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+// composeBuild converts build: <context> and the long syntax.
+func composeBuild(n *yaml.Node) (*ComposeBuild, error) {
+	if isNull(n) {
+		return nil, nil
+	}
+	if n.Kind == yaml.ScalarNode {
+		return &ComposeBuild{Context: n.Value}, nil
+	}
+	var raw struct {
+		Context    string    `yaml:"context"`
+		Dockerfile string    `yaml:"dockerfile"`
+		Args       yaml.Node `yaml:"args"`
+	}
+	if err := n.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if raw.Context == "" {
+		raw.Context = "."
+	}
+	args, err := composeMapping(&raw.Args)
+	if err != nil {
+		return nil, err
+	}
+	return &ComposeBuild{Context: raw.Context, Dockerfile: raw.Dockerfile, Args: args}, nil
+}
+
+// composeCommand converts a command given as string, which is split like a
+// shell would, or as list.
+func composeCommand(n *yaml.Node) ([]string, error) {
+	if n.Kind == yaml.ScalarNode && !isNull(n) {
+		args, err := splitCommand(n.Value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n.Line, err)
+		}
+		return args, nil
+	}
+	return composeList(n)
+}
+
+// composeList converts a single string or a list of strings.
+func composeList(n *yaml.Node) ([]string, error) {
+	switch {
+	case isNull(n):
+		return nil, nil
+	case n.Kind == yaml.ScalarNode:
+		return []string{n.Value}, nil
+	case n.Kind == yaml.SequenceNode:
+		var list []string
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: expected a string", item.Line)
+			}
+			list = append(list, item.Value)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("line %d: expected a string or a list of strings", n.Line)
+}
+
+// composeMapping converts a list of KEY=VALUE or a mapping. Keys without a
+// value are mapped to nil.
+func composeMapping(n *yaml.Node) (map[string]*string, error) {
+	m := make(map[string]*string)
+	switch {
+	case isNull(n):
+	case n.Kind == yaml.SequenceNode:
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: expected KEY=VALUE", item.Line)
+			}
+			if i := strings.Index(item.Value, "="); i >= 0 {
+				val := item.Value[i+1:]
+				m[item.Value[:i]] = &val
+			} else {
+				m[item.Value] = nil
+			}
+		}
+	case n.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			if val.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: expected a value for %s", val.Line, key.Value)
+			}
+			if isNull(val) {
+				m[key.Value] = nil
+			} else {
+				v := val.Value
+				m[key.Value] = &v
+			}
+		}
+	default:
+		return nil, fmt.Errorf("line %d: expected a list of KEY=VALUE or a mapping", n.Line)
+	}
+	return m, nil
+}
+
+// composePort converts a port in short syntax or long syntax to the format
+// of docker run -p.
+func composePort(n *yaml.Node) (string, error) {
+	spec := n.Value
+	if n.Kind == yaml.MappingNode {
+		var raw struct {
+			Target    string `yaml:"target"`
+			Published string `yaml:"published"`
+			Protocol  string `yaml:"protocol"`
+			HostIP    string `yaml:"host_ip"`
+		}
+		if err := n.Decode(&raw); err != nil {
+			return "", err
+		}
+		spec = raw.Target
+		if raw.Published != "" {
+			spec = raw.Published + ":" + spec
+			if raw.HostIP != "" {
+				spec = raw.HostIP + ":" + spec
+			}
+		}
+		if raw.Protocol != "" {
+			spec += "/" + raw.Protocol
+		}
+	} else if n.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("line %d: expected a port", n.Line)
+	}
+	if _, err := nat.ParsePortSpec(spec); err != nil {
+		return "", fmt.Errorf("line %d: %s", n.Line, err)
+	}
+	return spec, nil
+}
+
+// composeMount converts a volume in short syntax, SOURCE:TARGET[:MODE] or
+// TARGET, or in long syntax.
+func composeMount(n *yaml.Node) (ComposeMount, error) {
+	var m ComposeMount
+	switch n.Kind {
+	case yaml.ScalarNode:
+		parts := strings.Split(n.Value, ":")
+		switch len(parts) {
+		case 1:
+			m.Target = parts[0]
+		case 2, 3:
+			m.Source, m.Target = parts[0], parts[1]
+			if len(parts) == 3 {
+				for _, opt := range strings.Split(parts[2], ",") {
+					m.ReadOnly = m.ReadOnly || opt == "ro"
+				}
+			}
+		default:
+			return m, fmt.Errorf("line %d: invalid volume %s", n.Line, n.Value)
+		}
+		m.Type = "volume"
+		if strings.HasPrefix(m.Source, ".") || strings.HasPrefix(m.Source, "/") || strings.HasPrefix(m.Source, "~") {
+			m.Type = "bind"
+		}
+	case yaml.MappingNode:
+		var raw struct {
+			Type     string `yaml:"type"`
+			Source   string `yaml:"source"`
+			Target   string `yaml:"target"`
+			ReadOnly bool   `yaml:"read_only"`
+		}
+		if err := n.Decode(&raw); err != nil {
+			return m, err
+		}
+		m = ComposeMount{Type: raw.Type, Source: raw.Source, Target: raw.Target, ReadOnly: raw.ReadOnly}
+		if m.Type == "" {
+			m.Type = "volume"
+		}
+	default:
+		return m, fmt.Errorf("line %d: expected a volume", n.Line)
+	}
+
+	switch {
+	case m.Target == "":
+		return m, fmt.Errorf("line %d: volume without target", n.Line)
+	case m.Type == "bind" && m.Source == "":
+		return m, fmt.Errorf("line %d: bind mount without source", n.Line)
+	case m.Type != "volume" && m.Type != "bind" && m.Type != "tmpfs":
+		return m, fmt.Errorf("line %d: unsupported volume type %s", n.Line, m.Type)
+	}
+	return m, nil
+}
+
+// composeDependsOn converts a list of services or a mapping of services to
+// their condition.
+func composeDependsOn(n *yaml.Node) (map[string]string, error) {
+	deps := make(map[string]string)
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			var raw struct {
+				Condition string `yaml:"condition"`
+			}
+			if err := n.Content[i+1].Decode(&raw); err != nil {
+				return nil, err
+			}
+			switch raw.Condition {
+			case "":
+				raw.Condition = ComposeServiceStarted
+			case ComposeServiceStarted, ComposeServiceHealthy, ComposeServiceCompletedSuccessfully:
+			default:
+				return nil, fmt.Errorf("line %d: unknown condition %s", n.Content[i+1].Line, raw.Condition)
+			}
+			deps[n.Content[i].Value] = raw.Condition
+		}
+		return deps, nil
+	}
+
+	services, err := composeList(n)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range services {
+		deps[name] = ComposeServiceStarted
+	}
+	return deps, nil
+}
+
+// composeHealthcheck converts the health check to the docker configuration.
+func composeHealthcheck(n *yaml.Node) (*container.HealthConfig, error) {
+	if isNull(n) {
+		return nil, nil
+	}
+	var raw struct {
+		Test     yaml.Node `yaml:"test"`
+		Interval string    `yaml:"interval"`
+		Timeout  string    `yaml:"timeout"`
+		Retries  int       `yaml:"retries"`
+		Disable  bool      `yaml:"disable"`
+	}
+	if err := n.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if raw.Disable {
+		return &container.HealthConfig{Test: []string{"NONE"}}, nil
+	}
+
+	hc := &container.HealthConfig{Retries: raw.Retries}
+	if raw.Test.Kind == yaml.ScalarNode && !isNull(&raw.Test) {
+		hc.Test = []string{"CMD-SHELL", raw.Test.Value}
+	} else {
+		var err error
+		if hc.Test, err = composeList(&raw.Test); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range []struct {
+		value string
+		dst   *time.Duration
+	}{
+		{value: raw.Interval, dst: &hc.Interval},
+		{value: raw.Timeout, dst: &hc.Timeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		var err error
+		if *d.dst, err = time.ParseDuration(d.value); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n.Line, err)
+		}
+	}
+	return hc, nil
+}
+
+// composeNetworks converts a list of networks or a mapping of networks to
+// their options.
+func composeNetworks(n *yaml.Node) (map[string][]string, error) {
+	networks := make(map[string][]string)
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			var raw struct {
+				Aliases []string `yaml:"aliases"`
+			}
+			if !isNull(n.Content[i+1]) {
+				if err := n.Content[i+1].Decode(&raw); err != nil {
+					return nil, err
+				}
+			}
+			networks[n.Content[i].Value] = raw.Aliases
+		}
+		return networks, nil
+	}
+
+	names, err := composeList(n)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		networks[name] = nil
+	}
+	return networks, nil
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == 0 || n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+// interpolate substitutes ${VAR}, ${VAR:-default} and ${VAR-default} from
+// the environment, $$ is an escaped $.
+func interpolate(s string) string {
+	return os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		if i := strings.Index(name, ":-"); i > 0 {
+			if val := os.Getenv(name[:i]); val != "" {
+				return val
+			}
+			return name[i+2:]
+		}
+		if i := strings.Index(name, "-"); i > 0 {
+			if val, ok := os.LookupEnv(name[:i]); ok {
+				return val
+			}
+			return name[i+1:]
+		}
+		return os.Getenv(name)
+	})
+}
+
+// splitCommand splits a command into arguments like a shell, respecting
+// single and double quotes and backslash escapes.
+func splitCommand(s string) ([]string, error) {
+	var (
+		args  []string
+		arg   []rune
+		inArg bool
+		quote rune
+	)
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == '\'':
+			arg = append(arg, r)
+		case r == '\\' && i+1 < len(runes):
+			i++
+			arg, inArg = append(arg, runes[i]), true
+		case quote == '"':
+			arg = append(arg, r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args, arg, inArg = append(args, string(arg)), nil, false
+			}
+		default:
+			arg, inArg = append(arg, r), true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command: %s", s)
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+// Compose imports the services of a docker compose file into the suite,
+// which is useful to reuse the docker-compose.yml of local development.
+// All services are started in a single network, a service is started after
+// its dependencies are healthy, see Container.WaitFor. A service with a
+// healthcheck uses HealthCheckDocker. Named volumes are managed by the
+// first service using them, see VolumeOpts. Returns the containers by
+// service name.
+func (s *Suite) Compose(ctx context.Context, opts ComposeOpts) map[string]*Container { // nolint: gocyclo
+	f, err := os.Open(opts.Path)
+	if err != nil {
+		s.t.Fatalf("compose file opening failure: %s", err.Error())
+	}
+	file, err := ParseCompose(f)
+	f.Close() // nolint: errcheck
+	if err != nil {
+		s.t.Fatalf("compose file %s parsing failure: %s", opts.Path, err.Error())
+	}
+	order, err := file.order()
+	if err != nil {
+		s.t.Fatalf("compose file %s parsing failure: %s", opts.Path, err.Error())
+	}
+	dir := filepath.Dir(opts.Path)
+
+	n := s.network
+	if n == nil {
+		name := opts.Network
+		if name == "" {
+			name = s.name
+		}
+		n = s.Network(NetworkOpts{Name: name})
+	}
+
+	// services started as job, because other services wait for them to
+	// complete
+	jobs := make(map[string]bool)
+	for _, svc := range file.Services {
+		for dep, condition := range svc.DependsOn {
+			jobs[dep] = jobs[dep] || condition == ComposeServiceCompletedSuccessfully
+		}
+	}
+
+	containers := make(map[string]*Container, len(order))
+	volumeOwners := make(map[string]*Container)
+	for _, name := range order {
+		svc := file.Services[name]
+
+		copts, volumes, err := s.composeContainerOpts(ctx, name, svc, file, dir)
+		if err != nil {
+			s.t.Fatalf("compose service %s failure: %s", name, err.Error())
+		}
+		copts.Job = jobs[name]
+
+		// volumes used by another service before are only bound
+		var owned []VolumeOpts
+		var waitFor []*Container
+		for _, v := range volumes {
+			if owner, ok := volumeOwners[v.Name]; ok {
+				bind := v.Name + ":" + v.Target
+				if v.ReadOnly {
+					bind += ":ro"
+				}
+				copts.HostConfig.Binds = append(copts.HostConfig.Binds, bind)
+				waitFor = append(waitFor, owner)
+			} else {
+				owned = append(owned, v)
+			}
+		}
+		copts.Volumes = append(copts.Volumes, owned...)
+		if opts.Override != nil {
+			opts.Override(name, &copts)
+		}
+
+		c := s.Container(copts)
+		containers[name] = c
+		for _, v := range owned {
+			volumeOwners[v.Name] = c
+		}
+
+		var deps []*Container
+		for _, dep := range sortedKeys(svc.DependsOn) {
+			deps = append(deps, containers[dep])
+		}
+		waitFor = append(waitFor, deps...)
+		parent := composeParent(n, deps, waitFor)
+		if parent == nil {
+			n.After(c)
+		} else {
+			parent.After(c)
+		}
+		for _, cc := range waitFor {
+			if cc != parent {
+				c.WaitFor(cc)
+			}
+		}
+	}
+
+	printf("(setup ) %-25s - compose file imported: %s", s.name, opts.Path)
+	return containers
+}
+
+// composeParent returns the dependency, the container is started after. The
+// others are waited for, so they have to be started earlier in the
+// depth-first order of the tree, see Container.WaitFor. These are the
+// containers before the parent and in its subtree, since the container is
+// appended as the last child. The dependency started last, which satisfies
+// this, is chosen. Returns nil, if there is none, then the container is
+// started after the network and waits for all of them.
+func composeParent(n *Network, deps, waitFor []*Container) *Container {
+	containers := n.containers()
+	pos := make(map[*Container]int, len(containers))
+	for i, c := range containers {
+		pos[c] = i
+	}
+
+	var parent *Container
+	for _, dep := range deps {
+		if parent != nil && pos[dep] < pos[parent] {
+			continue
+		}
+		end := pos[dep] + len(dep.tree()) - 1
+		ok := true
+		for _, cc := range waitFor {
+			ok = ok && pos[cc] <= end
+		}
+		if ok {
+			parent = dep
+		}
+	}
+	return parent
+}
+
+// composeContainerOpts converts the service to container options, the named
+// volumes are returned separately.
+func (s *Suite) composeContainerOpts(ctx context.Context, name string, svc *ComposeService, file *ComposeFile, dir string) (ContainerOpts, []VolumeOpts, error) { // nolint: gocyclo
+	image := svc.Image
+	if svc.Build != nil {
+		if image == "" {
+			image = "localhost/testingdock/" + strings.ToLower(s.name+"_"+name) + ":latest"
+		}
+		if err := s.buildComposeImage(ctx, image, svc.Build, dir); err != nil {
+			return ContainerOpts{}, nil, err
+		}
+	}
+
+	exposed, bindings, err := nat.ParsePortSpecs(svc.Ports)
+	if err != nil {
+		return ContainerOpts{}, nil, err
+	}
+
+	ccfg := &container.Config{
+		Image:        image,
+		Cmd:          svc.Command,
+		Entrypoint:   svc.Entrypoint,
+		Env:          svc.Environment,
+		WorkingDir:   svc.WorkingDir,
+		User:         svc.User,
+		Healthcheck:  svc.Healthcheck,
+		ExposedPorts: exposed,
+	}
+	hcfg := &container.HostConfig{
+		PortBindings: bindings,
+		Privileged:   svc.Privileged,
+	}
+
+	var volumes []VolumeOpts
+	tmpfs := svc.Tmpfs
+	for _, m := range svc.Volumes {
+		switch {
+		case m.Type == "tmpfs":
+			tmpfs = append(tmpfs, m.Target)
+		case m.Type == "bind":
+			source := m.Source
+			if strings.HasPrefix(source, "~") {
+				source = filepath.Join(os.Getenv("HOME"), source[1:])
+			} else if !filepath.IsAbs(source) {
+				source = filepath.Join(dir, source)
+			}
+			if source, err = filepath.Abs(source); err != nil {
+				return ContainerOpts{}, nil, err
+			}
+			bind := source + ":" + m.Target
+			if m.ReadOnly {
+				bind += ":ro"
+			}
+			hcfg.Binds = append(hcfg.Binds, bind)
+		case m.Source != "" && file.ExternalVolumes[m.Source]:
+			bind := m.Source + ":" + m.Target
+			if m.ReadOnly {
+				bind += ":ro"
+			}
+			hcfg.Binds = append(hcfg.Binds, bind)
+		default:
+			// anonymous volumes get a name, so they are removed as well
+			v := VolumeOpts{Target: m.Target, ReadOnly: m.ReadOnly}
+			if m.Source != "" {
+				v.Name = s.name + "_" + m.Source
+			} else {
+				v.Name = fmt.Sprintf("%s_%s_%d", s.name, name, len(volumes))
+			}
+			volumes = append(volumes, v)
+		}
+	}
+
+	aliases := []string{name}
+	for _, network := range sortedKeys(svc.Networks) {
+		aliases = append(aliases, svc.Networks[network]...)
+	}
+
+	copts := ContainerOpts{
+		Name:       svc.ContainerName,
+		Config:     ccfg,
+		HostConfig: hcfg,
+		Tmpfs:      tmpfs,
+		Aliases:    aliases,
+	}
+	if copts.Name == "" {
+		copts.Name = s.name + "_" + name
+	}
+	if svc.Healthcheck != nil && (len(svc.Healthcheck.Test) == 0 || svc.Healthcheck.Test[0] != "NONE") {
+		copts.HealthCheck = HealthCheckDocker()
+	}
+	return copts, volumes, nil
+}
+
+// buildComposeImage builds the image of a service.
+func (s *Suite) buildComposeImage(ctx context.Context, tag string, build *ComposeBuild, dir string) error {
+	contextDir := build.Context
+	if !filepath.IsAbs(contextDir) {
+		contextDir = filepath.Join(dir, contextDir)
+	}
+	buildCtx, err := tarDir(contextDir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	labels := createTestingLabel()
+	labels[SuiteLabel] = s.name
+	if err = imageBuild(ctx, s.cli, buildCtx, types.ImageBuildOptions{
+		Tags:        []string{tag},
+		Dockerfile:  build.Dockerfile,
+		BuildArgs:   build.Args,
+		Labels:      labels,
+		Remove:      true,
+		ForceRemove: true,
+	}); err != nil {
+		return fmt.Errorf("image build failure of %s: %s", tag, err)
+	}
+	printf("(setup ) %-25s - image built in %s: %s", s.name, time.Since(now), tag)
+	s.addImage(tag)
+	return nil
+}
+
+// order returns the services sorted topologically by their dependencies,
+// services without dependencies between them are sorted by name.
+func (f *ComposeFile) order() ([]string, error) {
+	var (
+		order []string
+		state = make(map[string]int) // 1 visiting, 2 done
+		visit func(name string, path []string) error
+	)
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, dep := range sortedKeys(f.Services[name].DependsOn) {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range sortedKeys(f.Services) {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// sortedKeys returns the keys of a map with string keys in sorted order.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,140 @@
+package testingdock_test
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piotrkowalczuk/testingdock"
+)
+
+const composeFile = `
+version: "3.8"
+services:
+  postgres:
+    image: postgres:${TESTINGDOCK_PG_VERSION:-9.6}
+    environment:
+      POSTGRES_PASSWORD: secret
+      POSTGRES_DB:
+    ports:
+      - "15432:5432"
+    volumes:
+      - data:/var/lib/postgresql/data
+    healthcheck:
+      test: pg_isready -U postgres
+      interval: 1s
+      timeout: 3s
+      retries: 30
+    networks:
+      backend:
+        aliases: [db]
+  migrate:
+    build:
+      context: ./migrations
+      args:
+        - VERSION=1
+    command: migrate -path "/migrations dir" up
+    depends_on:
+      postgres:
+        condition: service_healthy
+  app:
+    image: app:latest
+    environment:
+      - MODE=test
+    ports:
+      - target: 8080
+        published: 18080
+        host_ip: 127.0.0.1
+    volumes:
+      - ./config:/etc/app:ro
+      - type: tmpfs
+        target: /tmp
+    depends_on:
+      migrate:
+        condition: service_completed_successfully
+      postgres:
+        condition: service_started
+    networks: [backend]
+volumes:
+  data:
+`
+
+func TestParseCompose(t *testing.T) {
+	os.Unsetenv("TESTINGDOCK_PG_VERSION") // nolint: errcheck
+
+	file, err := testingdock.ParseCompose(strings.NewReader(composeFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(file.Services) != 3 {
+		t.Fatalf("wrong number of services, expected 3 but got %d", len(file.Services))
+	}
+
+	pg := file.Services["postgres"]
+	if pg.Image != "postgres:9.6" {
+		t.Errorf("wrong image, expected postgres:9.6 but got %s", pg.Image)
+	}
+	if !reflect.DeepEqual(pg.Environment, []string{"POSTGRES_PASSWORD=secret"}) {
+		t.Errorf("wrong environment: %v", pg.Environment)
+	}
+	if !reflect.DeepEqual(pg.Ports, []string{"15432:5432"}) {
+		t.Errorf("wrong ports: %v", pg.Ports)
+	}
+	if !reflect.DeepEqual(pg.Volumes, []testingdock.ComposeMount{{Type: "volume", Source: "data", Target: "/var/lib/postgresql/data"}}) {
+		t.Errorf("wrong volumes: %v", pg.Volumes)
+	}
+	if hc := pg.Healthcheck; hc == nil || !reflect.DeepEqual(hc.Test, []string{"CMD-SHELL", "pg_isready -U postgres"}) ||
+		hc.Interval != time.Second || hc.Timeout != 3*time.Second || hc.Retries != 30 {
+		t.Errorf("wrong healthcheck: %+v", hc)
+	}
+	if !reflect.DeepEqual(pg.Networks, map[string][]string{"backend": {"db"}}) {
+		t.Errorf("wrong networks: %v", pg.Networks)
+	}
+
+	migrate := file.Services["migrate"]
+	if migrate.Build == nil || migrate.Build.Context != "./migrations" || *migrate.Build.Args["VERSION"] != "1" {
+		t.Errorf("wrong build: %+v", migrate.Build)
+	}
+	if !reflect.DeepEqual(migrate.Command, []string{"migrate", "-path", "/migrations dir", "up"}) {
+		t.Errorf("wrong command: %q", migrate.Command)
+	}
+
+	app := file.Services["app"]
+	if !reflect.DeepEqual(app.Ports, []string{"127.0.0.1:18080:8080"}) {
+		t.Errorf("wrong ports: %v", app.Ports)
+	}
+	if !reflect.DeepEqual(app.Volumes, []testingdock.ComposeMount{
+		{Type: "bind", Source: "./config", Target: "/etc/app", ReadOnly: true},
+		{Type: "tmpfs", Target: "/tmp"},
+	}) {
+		t.Errorf("wrong volumes: %v", app.Volumes)
+	}
+	if !reflect.DeepEqual(app.DependsOn, map[string]string{
+		"migrate":  testingdock.ComposeServiceCompletedSuccessfully,
+		"postgres": testingdock.ComposeServiceStarted,
+	}) {
+		t.Errorf("wrong dependencies: %v", app.DependsOn)
+	}
+}
+
+func TestParseCompose_errors(t *testing.T) {
+	cases := map[string]string{
+		"services:\n  app:\n    environment: [A=1]\n":                                                                        "neither image nor build set",
+		"services:\n  app:\n    image: app\n    depends_on: [db]\n":                                                          "line 4: service app depends on undefined service db",
+		"services:\n  app:\n    image: app\n    ports:\n      - 99999999\n":                                                  "line 5:",
+		"services:\n  app:\n    image: app\n    depends_on:\n      db:\n        condition: sometime\n  db:\n    image: db\n": "line 6: unknown condition sometime",
+		"services:\n  app:\n    image: app\n    command: \"echo 'unterminated\"\n":                                           "line 4: unterminated quote",
+	}
+	for given, expected := range cases {
+		_, err := testingdock.ParseCompose(strings.NewReader(given))
+		if err == nil {
+			t.Errorf("expected error %q, got nil", expected)
+			continue
+		}
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("wrong error, expected %q but got %q", expected, err.Error())
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"path"
 	"strings"
 	"sync"
 	"testing"
@@ -17,6 +18,7 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
 	clicfg "github.com/docker/docker/cli/config"
 	"github.com/docker/docker/client"
 )
@@ -52,6 +54,25 @@ func HealthCheckHTTP(url string) HealthCheckFunc {
 	}
 }
 
+// HealthCheckDocker is a pre-implemented HealthCheckFunc which checks the
+// status of the docker health check, configured in the image or in
+// container.Config.Healthcheck.
+func HealthCheckDocker() HealthCheckFunc {
+	return func(ctx context.Context, c *Container) error {
+		cjson, err := c.Inspect(ctx)
+		if err != nil {
+			return err
+		}
+		if cjson.State == nil || cjson.State.Health == nil {
+			return fmt.Errorf("container has no docker health check")
+		}
+		if status := cjson.State.Health.Status; status != types.Healthy {
+			return fmt.Errorf("docker health status: %s", status)
+		}
+		return nil
+	}
+}
+
 // HealthCheckCustom is just a convenience wrapper to set a HealthCheckFunc without any arguments.
 func HealthCheckCustom(fn func() error) HealthCheckFunc {
 	return func(ctx context.Context, c *Container) error {
@@ -70,6 +91,14 @@ func resetRestart() ResetFunc {
 	}
 }
 
+// resetRerun is a pre-implemented ResetFunc for jobs, which runs the job
+// container again and waits for it to succeed.
+func resetRerun() ResetFunc {
+	return func(ctx context.Context, c *Container) error {
+		return c.runJob(ctx)
+	}
+}
+
 // ResetCustom is just a convenience wrapper to set a ResetFunc.
 func ResetCustom(fn func() error) ResetFunc {
 	return func(ctx context.Context, c *Container) error {
@@ -81,7 +110,8 @@ func ResetCustom(fn func() error) ResetFunc {
 // configuration.
 type ContainerOpts struct {
 	ForcePull bool
-	// AutoRemove is always set to true
+	// AutoRemove is always set to false, so exited containers can be
+	// inspected, they are removed on close instead
 	Config     *container.Config
 	HostConfig *container.HostConfig
 	Name       string
@@ -93,8 +123,55 @@ type ContainerOpts struct {
 	// default is 30s
 	HealthCheckTimeout time.Duration
 	// Function called when the containers are reset. The zero value is
-	// a function, which will restart the container completely.
+	// a function, which will restart the container completely. See also
+	// ResetRecreate and ResetSnapshot.
 	Reset ResetFunc
+	// Snapshot commits the container into an image after its first healthy
+	// start, which ResetSnapshot recreates it from. The default Reset is
+	// ResetSnapshot then, set it explicitly to wrap it.
+	Snapshot bool
+	// Reuse adopts a running container left by a previous test run,
+	// if it was created from the same configuration, instead of
+	// recreating it. Only the ResetFunc and HealthCheckFunc are run on an
+	// adopted container. Reused containers are kept running on close.
+	// The host ports are part of the configuration, so a container
+	// publishing a RandomPort is never reused, use fixed host ports.
+	Reuse bool
+	// RestartOnCrash restarts the container if it dies unexpectedly.
+	// The crash is reported nevertheless.
+	RestartOnCrash bool
+	// Job marks a container, which runs to completion, e.g. database
+	// migrations. Instead of health checking, start waits for the job to
+	// exit and fails if the exit code is not zero. Children are started
+	// after the job succeeded. The zero value of Reset runs the job again.
+	Job bool
+	// Signal sent to stop the container on close, the zero value is
+	// the image default, usually SIGTERM.
+	StopSignal string
+	// Time to wait for the container to stop on close, before it is
+	// killed. The default is 10s.
+	StopTimeout time.Duration
+	// CleanExit makes close fail, if the container does not exit with
+	// code zero after it was stopped.
+	CleanExit bool
+	// Coverage collects Go coverage data from the container, see
+	// CoverageOpts.
+	Coverage *CoverageOpts
+	// Hooks called during the lifecycle of the container.
+	Hooks ContainerHooks
+	// Volumes are named volumes managed by testingdock, see VolumeOpts.
+	Volumes []VolumeOpts
+	// Tmpfs is a list of directories mounted as tmpfs, e.g. data
+	// directories, which makes writes fast and leaves nothing behind.
+	Tmpfs []string
+	// RestartChildren makes Stop and Kill stop the children first and
+	// Start start them again after the container is healthy.
+	RestartChildren bool
+	// Limits of the resources of the container, e.g. LimitsSmall, limits
+	// set in the HostConfig take precedence.
+	Limits Limits
+	// Aliases of the container in its network, in addition to its name.
+	Aliases []string
 }
 
 // Container is a docker container configuration,
@@ -105,17 +182,64 @@ type Container struct { // nolint: maligned
 	t                  testing.TB
 	forcePull          bool
 	cli                *client.Client
+	suite              *Suite
 	network            *Network
 	ccfg               *container.Config
 	hcfg               *container.HostConfig
 	ID, Name, Image    string
+	image              string // rewritten image reference, used for pulling and creation
 	healthcheck        HealthCheckFunc
 	healthchecktimeout time.Duration
 	// children are dependencies that are started after the main container
 	children []*Container
-	cancel   func()
-	resetF   ResetFunc
-	closed   bool
+	// waitFor are containers in other branches, which have to be ready
+	// before the container is started
+	waitFor   []*Container
+	ready     chan struct{} // closed once the start finished
+	readyOnce sync.Once
+	cancel    func(ctx context.Context)
+	resetF    ResetFunc
+	closed    bool
+	reuse     bool
+	hash      string // configuration hash, only set if reuse is enabled
+
+	// guards the crash detection state below
+	mu                       sync.Mutex
+	restartOnCrash           bool
+	oomKilled                bool
+	signal                   string
+	expectedFrom, expectedTo time.Time
+
+	job      bool
+	exitCode int
+	logs     []string
+
+	stopTimeout time.Duration
+	cleanExit   bool
+	coverage    *CoverageOpts
+	hooks       ContainerHooks
+	dirty       bool // guarded by mu
+	volumes     []VolumeOpts
+	aliases     []string
+	// start state, guarded by mu
+	starting, healthy bool
+
+	// state changed by the control API, guarded by mu
+	restartChildren bool
+	stopped         bool
+	paused          bool
+	// endpoint settings of the networks the container was disconnected from
+	disconnected map[string]*network.EndpointSettings
+	netem        *NetEmRules
+	timeline     timeline
+	stats        Stats
+	statsCancel  func()
+
+	// configuration the container was created with, used to recreate it
+	createCfg       *container.Config
+	createHostCfg   *container.HostConfig
+	snapshotOnStart bool
+	snapshot        string
 }
 
 // Creates a new container configuration with the given options.
@@ -124,17 +248,44 @@ func newContainer(t testing.TB, c *client.Client, opts ContainerOpts) *Container
 	if opts.HealthCheckTimeout == 0 { // zero value
 		opts.HealthCheckTimeout = 30 * time.Second
 	}
+	if opts.StopTimeout == 0 {
+		opts.StopTimeout = 10 * time.Second
+	}
+	if opts.StopSignal != "" {
+		opts.Config.StopSignal = opts.StopSignal
+	}
+	if opts.Coverage != nil {
+		if opts.Coverage.HostDir == "" {
+			t.Fatalf("coverage host directory of container %s not set", opts.Name)
+		}
+		if opts.Coverage.Dir == "" {
+			opts.Coverage.Dir = defaultCoverageDir
+		}
+		if !path.IsAbs(opts.Coverage.Dir) || path.Clean(opts.Coverage.Dir) == "/" {
+			t.Fatalf("coverage directory %s of container %s is not an absolute path below the root", opts.Coverage.Dir, opts.Name)
+		}
+		opts.Config.Env = append(opts.Config.Env, "GOCOVERDIR="+opts.Coverage.Dir)
+	}
 
-	// always autoremove
+	// never autoremove, crashed containers have to be inspected
 	if opts.HostConfig == nil {
 		opts.HostConfig = &container.HostConfig{}
 	}
-	opts.HostConfig.AutoRemove = true
+	opts.HostConfig.AutoRemove = false
+	opts.Limits.apply(opts.HostConfig)
+	volumes := volumeBinds(opts.Name, opts.Volumes, opts.Tmpfs, opts.HostConfig)
 
 	// set testingdock label
 	opts.Config.Labels = createTestingLabel()
+	opts.Config.Labels[imageLabel] = opts.Config.Image
 
 	// set default resetFunc
+	if opts.Reset == nil && opts.Snapshot {
+		opts.Reset = ResetSnapshot()
+	}
+	if opts.Reset == nil && opts.Job {
+		opts.Reset = resetRerun()
+	}
 	if opts.Reset == nil {
 		opts.Reset = resetRestart()
 	}
@@ -143,12 +294,26 @@ func newContainer(t testing.TB, c *client.Client, opts ContainerOpts) *Container
 		t:                  t,
 		forcePull:          opts.ForcePull,
 		Name:               opts.Name,
+		Image:              opts.Config.Image,
+		image:              opts.Config.Image,
 		healthcheck:        opts.HealthCheck,
 		healthchecktimeout: opts.HealthCheckTimeout,
 		cli:                c,
 		ccfg:               opts.Config,
 		hcfg:               opts.HostConfig,
 		resetF:             opts.Reset,
+		snapshotOnStart:    opts.Snapshot,
+		reuse:              opts.Reuse,
+		restartOnCrash:     opts.RestartOnCrash,
+		job:                opts.Job,
+		stopTimeout:        opts.StopTimeout,
+		cleanExit:          opts.CleanExit,
+		coverage:           opts.Coverage,
+		hooks:              opts.Hooks,
+		volumes:            volumes,
+		restartChildren:    opts.RestartChildren,
+		aliases:            opts.Aliases,
+		ready:              make(chan struct{}),
 	}
 
 	// set default healthcheck
@@ -165,66 +330,121 @@ func (c *Container) start(ctx context.Context) { // nolint: gocyclo
 		c.t.Fatalf("Container %s not added to any network!", c.Name)
 	}
 
-	imageListArgs := filters.NewArgs()
-	imageListArgs.Add("reference", c.ccfg.Image)
-
-	images, err := c.cli.ImageList(ctx, types.ImageListOptions{Filters: imageListArgs})
-	if err != nil {
-		c.t.Fatalf("image listing failure: %s", err.Error())
-	}
-
-	if len(images) == 0 || c.forcePull {
-		printf("(setup) %-25s - pulling image", c.ccfg.Image)
-		img, err := c.imagePull(ctx)
-		if err != nil {
-			c.t.Fatalf("image downloading failure of '%s': %s", c.ccfg.Image, err.Error())
-		}
-		if _, err = io.Copy(ioutil.Discard, img); err != nil {
-			c.t.Fatalf("image pull response read failure: %s", err.Error())
+	c.mu.Lock()
+	c.starting = true
+	c.mu.Unlock()
+	// on failure the children are never started, so the containers waiting
+	// for this one or any of its descendants are unblocked as well
+	defer func() {
+		c.mu.Lock()
+		healthy := c.healthy
+		c.mu.Unlock()
+		if healthy {
+			return
 		}
-		if err = img.Close(); err != nil {
-			c.t.Fatalf("image closing failure: %s", err.Error())
+		for _, cc := range c.tree() {
+			cc.readyOnce.Do(func() { close(cc.ready) })
 		}
-		printf("(setup) %-25s - successfully pulled image", c.ccfg.Image)
+	}()
+
+	if err := c.waitReady(ctx); err != nil {
+		c.t.Fatalf("container dependency failure: %s", err.Error())
 	}
 
-	c.initialCleanup(ctx)
+	// released before the children are started, or on failure
+	release := acquire()
+	defer release()
+
+	ccfg := *c.ccfg
+	ccfg.Image = c.image
 
 	hcfg := *c.hcfg
 	hcfg.NetworkMode = container.NetworkMode(c.network.name)
 
-	cont, err := c.cli.ContainerCreate(ctx, c.ccfg, &hcfg, nil, c.Name)
-	if err != nil {
-		c.t.Fatalf("container creation failure: %s", err.Error())
+	if c.reuse {
+		var err error
+		if c.hash, err = configHash(&ccfg, &hcfg); err != nil {
+			c.t.Fatalf("container configuration hashing failure: %s", err.Error())
+		}
+	}
+
+	ccfg.Labels = copyLabels(ccfg.Labels)
+	ccfg.Labels[SuiteLabel] = c.suite.name
+	if c.reuse {
+		ccfg.Labels[hashLabel] = c.hash
 	}
+	c.createCfg, c.createHostCfg = &ccfg, &hcfg
 
-	c.ID = cont.ID
+	if c.reuse && c.adopt(ctx) {
+		c.setCancel()
+		printf("(setup ) %-25s (%s) - container reused", c.Name, c.ID)
 
-	c.cancel = func() {
-		if c.closed {
-			return
+		c.mustHook(ctx, "BeforeReset", c.hooks.BeforeReset)
+		if err := c.expectExit(func() error { return c.resetF(ctx, c) }); err != nil {
+			c.t.Fatalf("container reset failure: %s", err.Error())
 		}
-		if err := c.cli.NetworkDisconnect(ctx, c.network.id, c.ID, true); err != nil {
-			c.t.Fatalf("container disconnect failure: %s", err.Error())
+		c.mustHook(ctx, "AfterReset", c.hooks.AfterReset)
+	} else {
+		done := c.phase(PhasePull)
+		c.pull(ctx)
+		done()
+		done = c.phase(PhaseCleanup)
+		c.initialCleanup(ctx)
+		done()
+
+		if err := c.createVolumes(ctx); err != nil {
+			c.t.Fatalf("volume creation failure: %s", err.Error())
 		}
-		printf("(cancel) %-25s (%s) - container disconnected from: %s", c.Name, c.ID, c.network.name)
-		if err := c.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
-			c.t.Fatalf("container removal failure: %s", err.Error())
+
+		c.mustHook(ctx, "BeforeCreate", c.hooks.BeforeCreate)
+		done = c.phase(PhaseCreate)
+		var ncfg *network.NetworkingConfig
+		if len(c.aliases) > 0 {
+			ncfg = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{
+				c.network.name: {Aliases: c.aliases},
+			}}
 		}
-		printf("(cancel) %-25s (%s) - container removed", c.Name, c.ID)
-	}
+		cont, err := c.cli.ContainerCreate(ctx, &ccfg, &hcfg, ncfg, c.Name)
+		if err != nil {
+			c.t.Fatalf("container creation failure: %s", err.Error())
+		}
+		c.ID = cont.ID
+		done()
 
-	// start the container finally
-	if err = c.cli.ContainerStart(ctx, c.ID, types.ContainerStartOptions{}); err != nil {
-		c.t.Fatalf("container start failure: %s", err.Error())
-	}
+		c.setCancel()
+
+		if c.coverage != nil {
+			if err = c.prepareCoverage(ctx); err != nil {
+				c.t.Fatalf("coverage directory creation failure: %s", err.Error())
+			}
+		}
+		if err = c.initVolumes(ctx); err != nil {
+			c.t.Fatalf("volume initialization failure: %s", err.Error())
+		}
+		c.mustHook(ctx, "AfterCreate", c.hooks.AfterCreate)
+
+		// start the container finally
+		done = c.phase(PhaseStart)
+		if c.job {
+			if err = c.runJob(ctx); err != nil {
+				c.t.Fatalf("job failure: %s", err.Error())
+			}
+		} else {
+			if err = c.cli.ContainerStart(ctx, c.ID, types.ContainerStartOptions{}); err != nil {
+				c.t.Fatalf("container start failure: %s", err.Error())
+			}
 
-	printf("(setup ) %-25s (%s) - container started", c.Name, c.ID)
+			printf("(setup ) %-25s (%s) - container started", c.Name, c.ID)
+		}
+		done()
+		c.mustHook(ctx, "AfterStart", c.hooks.AfterStart)
+	}
+	c.startSampling()
 
 	// start container logging
 	if Verbose {
 		go func() {
-			reader, gerr := c.cli.ContainerLogs(ctx, cont.ID, types.ContainerLogsOptions{
+			reader, gerr := c.cli.ContainerLogs(ctx, c.ID, types.ContainerLogsOptions{
 				ShowStdout: true,
 				ShowStderr: true,
 				Follow:     true,
@@ -252,27 +472,135 @@ func (c *Container) start(ctx context.Context) { // nolint: gocyclo
 		}()
 	}
 
-	c.executeHealthCheck(ctx)
+	// a successfully finished job is healthy by definition
+	if !c.job {
+		c.executeHealthCheck(ctx)
+	}
+	c.mustHook(ctx, "AfterHealthy", c.hooks.AfterHealthy)
+	if c.snapshotOnStart && c.snapshot == "" {
+		if err := c.takeSnapshot(ctx); err != nil {
+			c.t.Fatalf("container snapshot failure: %s", err.Error())
+		}
+	}
+	c.mu.Lock()
+	c.healthy = true
+	c.mu.Unlock()
+	c.readyOnce.Do(func() { close(c.ready) })
+	release()
 
 	// start children
-	if SpawnSequential {
-		for _, cont := range c.children {
-			cont.start(ctx)
-		}
-	} else {
+	if !SpawnSequential {
 		printf("(setup ) %-25s (%s) - container is spawning %d child containers in parallel", c.Name, c.ID, len(c.children))
+	}
+	forEach(c.children, func(cc *Container) error { // nolint: errcheck
+		cc.start(ctx)
+		return nil
+	})
+}
 
-		var wg sync.WaitGroup
+// pull pulls the container image if it is not present yet or if
+// ForcePull is set.
+func (c *Container) pull(ctx context.Context) {
+	if c.image != c.Image {
+		printf("(setup ) %-25s - image rewritten to: %s", c.Image, c.image)
+	}
+	if err := c.pullImage(ctx, c.image, c.forcePull); err != nil {
+		c.t.Fatalf("image downloading failure of '%s': %s", c.Image, err.Error())
+	}
+}
 
-		wg.Add(len(c.children))
-		for _, cont := range c.children {
-			go func(cont *Container) {
-				defer wg.Done()
-				cont.start(ctx)
-			}(cont)
+// pullImage pulls the already rewritten image, if it is not present yet or
+// if forced, using the registry credentials.
+func (c *Container) pullImage(ctx context.Context, image string, force bool) error {
+	imageListArgs := filters.NewArgs()
+	imageListArgs.Add("reference", image)
+
+	images, err := c.cli.ImageList(ctx, types.ImageListOptions{Filters: imageListArgs})
+	if err != nil {
+		return fmt.Errorf("image listing failure: %s", err)
+	}
+	if len(images) > 0 && !force {
+		return nil
+	}
+
+	printf("(setup) %-25s - pulling image", image)
+	img, err := c.imagePull(ctx, image)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(ioutil.Discard, img); err != nil {
+		img.Close() // nolint: errcheck
+		return fmt.Errorf("image pull response read failure: %s", err)
+	}
+	if err = img.Close(); err != nil {
+		return fmt.Errorf("image closing failure: %s", err)
+	}
+	printf("(setup) %-25s - successfully pulled image", image)
+	return nil
+}
+
+// setCancel sets the function removing the container on close.
+func (c *Container) setCancel() {
+	c.cancel = func(ctx context.Context) {
+		if c.closed {
+			return
+		}
+		if c.reuse {
+			printf("(cancel) %-25s (%s) - container kept for reuse", c.Name, c.ID)
+			return
+		}
+		if !c.isDisconnected(c.network) {
+			if err := c.cli.NetworkDisconnect(ctx, c.network.id, c.ID, true); err != nil {
+				c.t.Fatalf("container disconnect failure: %s", err.Error())
+			}
+			printf("(cancel) %-25s (%s) - container disconnected from: %s", c.Name, c.ID, c.network.name)
+		}
+		done := c.phase(PhaseRemove)
+		if err := c.expectExit(func() error {
+			return c.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+		}); err != nil {
+			c.t.Fatalf("container removal failure: %s", err.Error())
+		}
+		done()
+		printf("(cancel) %-25s (%s) - container removed", c.Name, c.ID)
+		if err := c.removeVolumes(ctx); err != nil {
+			c.t.Fatalf("volume removal failure: %s", err.Error())
+		}
+		if err := c.removeSnapshot(ctx); err != nil {
+			c.t.Fatalf("container snapshot removal failure: %s", err.Error())
+		}
+	}
+}
+
+// adopt looks for a running container left by a previous run, which has
+// the same name and configuration hash, and takes it over. Returns false
+// if there is no such container.
+func (c *Container) adopt(ctx context.Context) bool {
+	containers, err := findContainerByName(ctx, c.cli, c.Name)
+	if err != nil {
+		c.t.Fatalf("container listing failure: %s", err.Error())
+	}
+	for _, cont := range containers {
+		if !hasName(cont.Names, c.Name) || !isOwnedByTestingdock(cont.Labels) {
+			continue
 		}
-		wg.Wait()
+		if cont.State != "running" {
+			printf("(setup ) %-25s (%s) - container not running, recreating", c.Name, cont.ID)
+			continue
+		}
+		if cont.Labels[hashLabel] != c.hash {
+			reason := "configuration changed"
+			if cjson, err := c.cli.ContainerInspect(ctx, cont.ID); err == nil && cjson.HostConfig != nil &&
+				!samePorts(cjson.HostConfig.PortBindings, c.createHostCfg.PortBindings) {
+				reason = "host ports changed, e.g. by RandomPort"
+			}
+			printf("(setup ) %-25s (%s) - container %s, recreating", c.Name, cont.ID, reason)
+			continue
+		}
+		c.ID = cont.ID
+		return true
 	}
+	return false
 }
 
 // Find containers by the given name.
@@ -280,6 +608,7 @@ func findContainerByName(ctx context.Context, cli *client.Client, name string) (
 	containerListArgs := filters.NewArgs()
 	containerListArgs.Add("name", name)
 	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
 		Filters: containerListArgs,
 	})
 	if err != nil {
@@ -298,6 +627,10 @@ func (c *Container) initialCleanup(ctx context.Context) {
 		c.t.Fatalf("container listing failure: %s", err.Error())
 	}
 	for _, cont := range containers {
+		// the name filter matches substrings as well
+		if !hasName(cont.Names, c.Name) {
+			continue
+		}
 		if isOwnedByTestingdock(cont.Labels) {
 			if err = c.cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{
 				Force:         true,
@@ -312,34 +645,166 @@ func (c *Container) initialCleanup(ctx context.Context) {
 	}
 }
 
-// Closes a container and its children. This calls the
-// 'cancel' function set in the Container struct.
-// Implements io.Closer interface.
-func (c *Container) close() error {
-	if SpawnSequential {
-		for _, cont := range c.children {
-			cont.close() // nolint: errcheck
+// Gracefully stops the children and then the container itself, so
+// services can shut down while their dependencies are still alive.
+// Returns an error if the container is expected to exit cleanly, but
+// its exit code is not zero.
+func (c *Container) stop(ctx context.Context) error {
+	err := forEach(c.children, func(cc *Container) error {
+		return cc.stop(ctx)
+	})
+
+	// if the container failed to start there is nothing to stop,
+	// reused containers are kept running
+	if c.ID == "" || c.closed || c.reuse {
+		return err
+	}
+
+	defer acquire()()
+
+	if herr := c.hook(ctx, "BeforeStop", c.hooks.BeforeStop); herr != nil && err == nil {
+		err = herr
+	}
+
+	c.mu.Lock()
+	paused, stopped := c.paused, c.stopped
+	c.mu.Unlock()
+	// a paused container can not be stopped gracefully
+	if paused {
+		if perr := c.Unpause(ctx); perr != nil {
+			c.t.Fatalf("container unpause failure: %s", perr.Error())
 		}
-	} else {
-		var wg sync.WaitGroup
+	}
+
+	timeout := c.stopTimeout
+	done := c.phase(PhaseStop)
+	if serr := c.expectExit(func() error {
+		return c.cli.ContainerStop(ctx, c.ID, &timeout)
+	}); serr != nil {
+		c.t.Fatalf("container stop failure: %s", serr.Error())
+	}
+	done()
+
+	cjson, ierr := c.Inspect(ctx)
+	if ierr != nil {
+		c.t.Fatalf("container inspect failure: %s", ierr.Error())
+	}
+	code := cjson.State.ExitCode
+	printf("(cancel) %-25s (%s) - container stopped with exit code %d", c.Name, c.ID, code)
+
+	c.mu.Lock()
+	c.exitCode = code
+	c.mu.Unlock()
 
-		wg.Add(len(c.children))
-		for _, cont := range c.children {
-			go func(cont *Container) {
-				defer wg.Done()
-				cont.close() // nolint: errcheck
-			}(cont)
+	// the exit code of a container stopped by the test is not checked
+	if c.cleanExit && !stopped && code != 0 && err == nil {
+		err = fmt.Errorf("container %s exited with code %d", c.Name, code)
+	}
+	if c.coverage != nil {
+		if cerr := c.collectCoverage(ctx); cerr != nil && err == nil {
+			err = fmt.Errorf("container %s coverage collection failure: %s", c.Name, cerr)
 		}
-		wg.Wait()
+	}
+	return err
+}
+
+// Closes a container and its children. This calls the
+// 'cancel' function set in the Container struct.
+func (c *Container) close(ctx context.Context) error {
+	err := forEach(c.children, func(cc *Container) error {
+		return cc.close(ctx)
+	})
+
+	if c.statsCancel != nil {
+		c.statsCancel()
 	}
 
 	// if the container failed to start c.cancel will not be set
-	if c.cancel != nil {
-		c.cancel()
+	if c.cancel != nil && !c.closed {
+		c.cancel(ctx)
+		if herr := c.hook(ctx, "AfterClose", c.hooks.AfterClose); herr != nil && err == nil {
+			err = herr
+		}
 	}
 
 	c.closed = true
-	return nil
+	return err
+}
+
+// acquire blocks until one more container may run a lifecycle step,
+// according to Parallelism. The returned function releases the slot, it
+// may be called more than once, e.g. deferred in case of a failure.
+func acquire() func() {
+	slotsOnce.Do(func() {
+		if Parallelism > 0 {
+			slots = make(chan struct{}, Parallelism)
+		}
+	})
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	var once sync.Once
+	return func() { once.Do(func() { <-slots }) }
+}
+
+// forEach calls the function for all the containers, in parallel unless
+// SpawnSequential is set. Returns the first error.
+func forEach(containers []*Container, fn func(*Container) error) error {
+	if SpawnSequential {
+		var first error
+		for _, cont := range containers {
+			if err := fn(cont); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+
+	wg.Add(len(containers))
+	for _, cont := range containers {
+		go func(cont *Container) {
+			defer wg.Done()
+			if err := fn(cont); err != nil {
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+				mu.Unlock()
+			}
+		}(cont)
+	}
+	wg.Wait()
+	return first
+}
+
+// tree returns the container and all its descendants, parents first.
+func (c *Container) tree() []*Container {
+	all := []*Container{c}
+	for _, cc := range c.children {
+		all = append(all, cc.tree()...)
+	}
+	return all
+}
+
+// reusable returns true if the container or any of its children is
+// reused across test runs.
+func (c *Container) reusable() bool {
+	if c.reuse {
+		return true
+	}
+	for _, cc := range c.children {
+		if cc.reusable() {
+			return true
+		}
+	}
+	return false
 }
 
 // After adds a child container (dependency, sort of)
@@ -349,44 +814,182 @@ func (c *Container) After(cc *Container) {
 	c.children = append(c.children, cc)
 }
 
+// WaitFor makes the container wait with its start until the given containers
+// in other branches of the tree are healthy, in addition to its parent, e.g.
+// for a service depending on two databases started in parallel:
+//  network.After(postgres)
+//  network.After(redis)
+//  postgres.After(service)
+//  service.WaitFor(redis)
+// With SpawnSequential the containers waited for have to be started earlier
+// in the depth-first order of the tree, otherwise the start fails.
+func (c *Container) WaitFor(cc ...*Container) {
+	c.waitFor = append(c.waitFor, cc...)
+}
+
+// waitReady blocks until all containers the container waits for finished
+// their start. Returns an error if one of them failed.
+func (c *Container) waitReady(ctx context.Context) error {
+	for _, cc := range c.waitFor {
+		cc.mu.Lock()
+		starting := cc.starting
+		cc.mu.Unlock()
+		if SpawnSequential && !starting {
+			return fmt.Errorf("container %s waits for %s, which is started after it", c.Name, cc.Name)
+		}
+
+		select {
+		case <-cc.ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		cc.mu.Lock()
+		healthy := cc.healthy
+		cc.mu.Unlock()
+		if !healthy {
+			return fmt.Errorf("container %s waits for %s, which failed to start", c.Name, cc.Name)
+		}
+	}
+	return nil
+}
+
 // Calls the ResetFunc set in the Container struct for the
 // whole configuration, including children containers.
 // Aborts early if there is any error during reset.
 func (c *Container) reset(ctx context.Context) {
-	if err := c.resetF(ctx, c); err != nil {
-		c.t.Fatalf("container reset failure: %s", err.Error())
-	}
-	c.executeHealthCheck(ctx)
+	// the slot is released on failure as well
+	func() {
+		release := acquire()
+		defer release()
+		done := c.phase(PhaseReset)
+		c.mustHook(ctx, "BeforeReset", c.hooks.BeforeReset)
+		if err := c.expectExit(func() error { return c.resetF(ctx, c) }); err != nil {
+			c.t.Fatalf("container reset failure: %s", err.Error())
+		}
+		c.setStopped(false)
+		if !c.job {
+			c.executeHealthCheck(ctx)
+		}
+		c.mustHook(ctx, "AfterReset", c.hooks.AfterReset)
+		done()
+	}()
 
-	for _, cc := range c.children {
+	c.mu.Lock()
+	c.dirty = false
+	c.mu.Unlock()
+	printf("(reset ) %-25s (%s) - container reset", c.Name, c.ID)
+
+	// siblings do not depend on each other
+	forEach(c.children, func(cc *Container) error { // nolint: errcheck
 		cc.reset(ctx)
+		return nil
+	})
+}
+
+// MarkDirty marks the container as touched by a test, so it is reset by
+// Suite.ResetDirty. Children are reset with their parent.
+func (c *Container) MarkDirty() {
+	c.mu.Lock()
+	c.dirty = true
+	c.mu.Unlock()
+}
+
+// isDirty returns true if the container was marked dirty since its last reset.
+func (c *Container) isDirty() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dirty
+}
+
+// runJob starts the job container, waits for it to exit and captures its
+// exit code and output. Returns an error if the exit code is not zero.
+func (c *Container) runJob(ctx context.Context) error {
+	var code int64
+	since := time.Now()
+	err := c.expectExit(func() error {
+		if err := c.cli.ContainerStart(ctx, c.ID, types.ContainerStartOptions{}); err != nil {
+			return err
+		}
+		printf("(setup ) %-25s (%s) - job started", c.Name, c.ID)
+
+		var err error
+		code, err = c.cli.ContainerWait(ctx, c.ID)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	printf("(reset ) %-25s (%s) - container reset", c.Name, c.ID)
+	logs, err := logLines(ctx, c.cli, c.ID, c.ccfg.Tty, types.ContainerLogsOptions{
+		Since: timestamp(since),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.exitCode, c.logs = int(code), logs
+	c.mu.Unlock()
+
+	if code != 0 {
+		return fmt.Errorf("job %s exited with code %d, output:\n%s", c.Name, code, strings.Join(logs, "\n"))
+	}
+	printf("(setup ) %-25s (%s) - job succeeded", c.Name, c.ID)
+	return nil
+}
+
+// ExitCode returns the exit code of the last run of a job container or
+// the exit code of a container stopped on close.
+func (c *Container) ExitCode() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exitCode
+}
+
+// Logs returns the output of the last run of a job container.
+func (c *Container) Logs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.logs
 }
 
 // Blocks until either the healthcheck returns no error or the context
 // is cancelled.
 func (c *Container) executeHealthCheck(ctx context.Context) {
+	if err := c.waitHealthy(ctx); err != nil {
+		c.t.Fatalf("health check failure: %s", err)
+	}
+}
+
+// waitHealthy blocks until the health check succeeds or the health check
+// timeout is exceeded.
+func (c *Container) waitHealthy(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, c.healthchecktimeout)
 	defer cancel()
-InfLoop:
-	for {
+	defer c.phase(PhaseHealthy)()
+	for probes := 0; ; probes++ {
 		select {
 		case <-ctx.Done():
-			c.t.Fatalf("health check failure: %s", ctx.Err())
+			return ctx.Err()
 		case <-time.After(1 * time.Second):
-			if err := c.healthcheck(ctx, c); err != nil {
+			done := func() {}
+			if probes == 0 {
+				done = c.phase(PhaseFirstProbe)
+			}
+			err := c.healthcheck(ctx, c)
+			done()
+			if err != nil {
 				printf("(setup ) %-25s (%s) - container health failure: %s", c.Name, c.ID, err.Error())
-				continue InfLoop
+				continue
 			}
-			break InfLoop
+			return nil
 		}
 	}
 }
 
 // wrapper around cli.ImagePull to fill ImagePullOptions with authentication information, if any.
-func (c *Container) imagePull(ctx context.Context) (io.ReadCloser, error) {
+func (c *Container) imagePull(ctx context.Context, image string) (io.ReadCloser, error) {
 	pullOptions := types.ImagePullOptions{}
 
 	// https://github.com/docker/distribution/blob/master/reference/reference.go#L7
@@ -396,7 +999,7 @@ func (c *Container) imagePull(ctx context.Context) (io.ReadCloser, error) {
 	//
 	// There is an undocumented hack to determine whether the first component is an actual domain, but it's
 	// shit: https://github.com/docker/distribution/blob/545102ea07aa9796f189d82f606b7c27d7aa3ed3/reference/normalize.go#L62
-	nameParts := strings.SplitN(c.ccfg.Image, "/", 2)
+	nameParts := strings.SplitN(image, "/", 2)
 
 	// get the credentials
 	if len(nameParts) >= 2 { // e.g.: quay.io/hans/myimage:latest
@@ -409,11 +1012,11 @@ func (c *Container) imagePull(ctx context.Context) (io.ReadCloser, error) {
 		if err == nil {
 			pullOptions.RegistryAuth = token
 		} else {
-			printf("(setup) %-25s - failed to get credentials, not fatal (%s)", c.ccfg.Image, err)
+			printf("(setup) %-25s - failed to get credentials, not fatal (%s)", image, err)
 		}
 	}
 
-	return c.cli.ImagePull(ctx, c.ccfg.Image, pullOptions)
+	return c.cli.ImagePull(ctx, image, pullOptions)
 }
 
 // get credentials from ~/.docker/config.json
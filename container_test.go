@@ -3,9 +3,18 @@ package testingdock_test
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 	_ "github.com/lib/pq"
 	"github.com/piotrkowalczuk/testingdock"
@@ -114,3 +123,452 @@ func testQueries(t *testing.T, db *sql.DB) {
 		t.Fatalf("insert error: %s", err.Error())
 	}
 }
+
+func TestContainer_Reuse(t *testing.T) {
+	var ids []string
+	for i := 0; i < 3; i++ {
+		s, ok := testingdock.GetOrCreateSuite(t, fmt.Sprintf("TestContainer_Reuse_%d", i), testingdock.SuiteOpts{})
+		if ok {
+			t.Fatal("this suite should not exists yet")
+		}
+
+		n := s.Network(testingdock.NetworkOpts{
+			Name: "TestContainer_Reuse",
+		})
+		c := s.Container(testingdock.ContainerOpts{
+			Name: "TestContainer_Reuse_postgres",
+			Config: &container.Config{
+				Image: "postgres:9.6",
+			},
+			// the last suite does not reuse, so everything gets cleaned up
+			Reuse: i < 2,
+		})
+		n.After(c)
+
+		s.Start(context.TODO())
+		ids = append(ids, c.ID)
+
+		if err := s.Close(); err != nil {
+			t.Fatalf("suite close failure: %s", err.Error())
+		}
+	}
+
+	if ids[0] != ids[1] {
+		t.Errorf("container should be reused, expected %s but got %s", ids[0], ids[1])
+	}
+	if ids[1] == ids[2] {
+		t.Errorf("container should be recreated by a suite without reuse")
+	}
+}
+
+func TestContainer_Job(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestContainer_Job", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	n := s.Network(testingdock.NetworkOpts{
+		Name: "TestContainer_Job",
+	})
+	job := s.Container(testingdock.ContainerOpts{
+		Name: "TestContainer_Job_migrate",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"echo", "migrated"},
+		},
+		Job: true,
+	})
+	app := s.Container(testingdock.ContainerOpts{
+		Name: "TestContainer_Job_app",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+	})
+	n.After(job)
+	job.After(app)
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	if job.ExitCode() != 0 {
+		t.Errorf("wrong exit code: %d", job.ExitCode())
+	}
+	if logs := job.Logs(); len(logs) != 1 || logs[0] != "migrated" {
+		t.Errorf("wrong logs: %v", logs)
+	}
+	if app.ID == "" {
+		t.Error("app should be started after the job")
+	}
+
+	s.Reset(context.TODO())
+
+	if logs := job.Logs(); len(logs) != 1 || logs[0] != "migrated" {
+		t.Errorf("wrong logs after reset: %v", logs)
+	}
+}
+
+func TestContainer_Hooks(t *testing.T) {
+	var calls []string
+	record := func(name string) testingdock.ContainerHook {
+		return func(ctx context.Context, c *testingdock.Container) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+
+	s, ok := testingdock.GetOrCreateSuite(t, "TestContainer_Hooks", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	n := s.Network(testingdock.NetworkOpts{
+		Name: "TestContainer_Hooks",
+	})
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestContainer_Hooks",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		StopSignal: "SIGKILL",
+		Hooks: testingdock.ContainerHooks{
+			BeforeCreate: record("BeforeCreate"),
+			AfterCreate:  record("AfterCreate"),
+			AfterStart:   record("AfterStart"),
+			AfterHealthy: record("AfterHealthy"),
+			BeforeReset:  record("BeforeReset"),
+			AfterReset:   record("AfterReset"),
+			BeforeStop:   record("BeforeStop"),
+			AfterClose:   record("AfterClose"),
+		},
+	})
+	n.After(c)
+
+	s.Start(context.TODO())
+	s.Reset(context.TODO())
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exp := "BeforeCreate AfterCreate AfterStart AfterHealthy BeforeReset AfterReset BeforeStop AfterClose"
+	if got := strings.Join(calls, " "); got != exp {
+		t.Errorf("wrong hook order, expected:\n	%s\nbut got:\n	%s", exp, got)
+	}
+}
+
+func TestContainer_ResetStrategies(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestContainer_ResetStrategies", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	n := s.Network(testingdock.NetworkOpts{
+		Name: "TestContainer_ResetStrategies",
+	})
+	opts := func(name, cmd string, reset testingdock.ResetFunc) testingdock.ContainerOpts {
+		return testingdock.ContainerOpts{
+			Name: name,
+			Config: &container.Config{
+				Image: "alpine:3.6",
+				Cmd:   []string{"sh", "-c", cmd},
+			},
+			StopSignal: "SIGKILL",
+			Reset:      reset,
+		}
+	}
+	// exits if the filesystem is not fresh, e.g. after a restart
+	recreate := s.Container(opts("TestContainer_ResetStrategies_recreate",
+		"test ! -f /started && touch /started && sleep 60", testingdock.ResetRecreate()))
+	snapshotOpts := opts("TestContainer_ResetStrategies_snapshot", "sleep 60", nil)
+	snapshotOpts.Snapshot = true
+	snapshot := s.Container(snapshotOpts)
+	n.After(recreate)
+	n.After(snapshot)
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	ids := []string{recreate.ID, snapshot.ID}
+	// written after the snapshot was taken
+	if err := testingdock.HealthCheckExec("touch", "/dirty")(context.TODO(), snapshot); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s.Reset(context.TODO())
+
+	if err := testingdock.HealthCheckExec("test", "!", "-f", "/dirty")(context.TODO(), snapshot); err != nil {
+		t.Errorf("data written after the snapshot should be gone: %s", err)
+	}
+
+	for i, c := range []*testingdock.Container{recreate, snapshot} {
+		if c.ID == ids[i] {
+			t.Errorf("container %s should be recreated", c.Name)
+		}
+		cjson, err := c.Inspect(context.TODO())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !cjson.State.Running {
+			t.Errorf("container %s should be running", c.Name)
+		}
+	}
+}
+
+func TestContainer_Volumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestContainer_Volumes")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "seed"), []byte("seeded"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s, ok := testingdock.GetOrCreateSuite(t, "TestContainer_Volumes", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	n := s.Network(testingdock.NetworkOpts{
+		Name: "TestContainer_Volumes",
+	})
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestContainer_Volumes",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			// exits if the volume is not initialized
+			Cmd: []string{"sh", "-c", "grep -q seeded /data/seed && touch /tmp/started && sleep 60"},
+		},
+		StopSignal: "SIGKILL",
+		Volumes: []testingdock.VolumeOpts{
+			{Name: "TestContainer_Volumes_data", Target: "/data", HostDir: dir},
+		},
+		Tmpfs: []string{"/tmp"},
+	})
+	n.After(c)
+
+	s.Start(context.TODO())
+
+	cjson, err := c.Inspect(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !cjson.State.Running {
+		t.Error("container should be running")
+	}
+	if _, ok := cjson.HostConfig.Tmpfs["/tmp"]; !ok {
+		t.Errorf("tmpfs should be mounted, got: %v", cjson.HostConfig.Tmpfs)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = cli.VolumeInspect(context.TODO(), "TestContainer_Volumes_data"); !client.IsErrVolumeNotFound(err) {
+		t.Errorf("volume should be removed on close, got: %v", err)
+	}
+}
+
+func TestContainer_Control(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestContainer_Control", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	n := s.Network(testingdock.NetworkOpts{
+		Name: "TestContainer_Control",
+	})
+	opts := func(name string) testingdock.ContainerOpts {
+		return testingdock.ContainerOpts{
+			Name: name,
+			Config: &container.Config{
+				Image: "alpine:3.6",
+				Cmd:   []string{"sleep", "60"},
+			},
+			StopSignal:      "SIGKILL",
+			RestartChildren: true,
+		}
+	}
+	parent := s.Container(opts("TestContainer_Control_parent"))
+	child := s.Container(opts("TestContainer_Control_child"))
+	n.After(parent)
+	parent.After(child)
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	running := func(c *testingdock.Container) bool {
+		cjson, err := c.Inspect(context.TODO())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return cjson.State.Running && !cjson.State.Paused
+	}
+
+	if err := parent.Stop(context.TODO(), time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if running(parent) || running(child) {
+		t.Error("parent and child should be stopped")
+	}
+	if err := parent.Start(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !running(parent) || !running(child) {
+		t.Error("parent and child should be running")
+	}
+
+	if err := child.Pause(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if running(child) {
+		t.Error("child should be paused")
+	}
+	if err := child.Unpause(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := child.Kill(context.TODO(), ""); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if running(child) {
+		t.Error("child should be killed")
+	}
+	if err := child.Restart(context.TODO(), time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !running(child) {
+		t.Error("child should be running")
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("expected exits should not be reported as crash: %s", err)
+	}
+}
+
+func TestContainer_Stats(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestContainer_Stats", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	n := s.Network(testingdock.NetworkOpts{
+		Name: "TestContainer_Stats",
+	})
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestContainer_Stats",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sh", "-c", "while true; do :; done"},
+		},
+		StopSignal: "SIGKILL",
+		Limits:     testingdock.LimitsSmall,
+	})
+	n.After(c)
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	cjson, err := c.Inspect(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cjson.HostConfig.Memory != testingdock.LimitsSmall.Memory {
+		t.Errorf("wrong memory limit: %d", cjson.HostConfig.Memory)
+	}
+	if cjson.HostConfig.NanoCPUs != 5e8 {
+		t.Errorf("wrong cpu limit: %d", cjson.HostConfig.NanoCPUs)
+	}
+
+	time.Sleep(3 * time.Second)
+
+	stats := c.Stats()
+	if stats.Samples == 0 {
+		t.Fatal("expected stats to be sampled")
+	}
+	if stats.CPU == 0 || stats.PeakMemory == 0 {
+		t.Errorf("expected cpu and memory usage, got: %s", stats)
+	}
+}
+
+// fatalT records the failures of a suite, instead of failing the test.
+// Fatal failures exit the goroutine, so the suite has to be started in
+// another one.
+type fatalT struct {
+	testing.TB
+	mu       sync.Mutex
+	failures []string
+}
+
+func (t *fatalT) Fatalf(format string, args ...interface{}) {
+	t.mu.Lock()
+	t.failures = append(t.failures, fmt.Sprintf(format, args...))
+	t.mu.Unlock()
+	runtime.Goexit()
+}
+
+func (t *fatalT) Errorf(format string, args ...interface{}) {
+	t.mu.Lock()
+	t.failures = append(t.failures, fmt.Sprintf(format, args...))
+	t.mu.Unlock()
+}
+
+func TestContainer_WaitFor(t *testing.T) {
+	ft := &fatalT{TB: t}
+	s, ok := testingdock.GetOrCreateSuite(ft, "TestContainer_WaitFor", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	defer s.Down(context.TODO())
+
+	opts := func(name string) testingdock.ContainerOpts {
+		return testingdock.ContainerOpts{
+			Name: name,
+			Config: &container.Config{
+				Image: "alpine:3.6",
+				Cmd:   []string{"sleep", "60"},
+			},
+			StopSignal: "SIGKILL",
+		}
+	}
+	broken := opts("TestContainer_WaitFor_broken")
+	broken.HealthCheckTimeout = time.Second
+	broken.HealthCheck = func(ctx context.Context, c *testingdock.Container) error {
+		return fmt.Errorf("never healthy")
+	}
+
+	n := s.Network(testingdock.NetworkOpts{Name: "TestContainer_WaitFor"})
+	parent := s.Container(broken)
+	child := s.Container(opts("TestContainer_WaitFor_child"))
+	waiting := s.Container(opts("TestContainer_WaitFor_waiting"))
+	n.After(parent)
+	n.After(waiting)
+	parent.After(child)
+	// the child is never started, since its parent fails
+	waiting.WaitFor(child)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Start(context.TODO())
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("start blocked by a container, which is never started")
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	var found bool
+	for _, f := range ft.failures {
+		found = found || strings.Contains(f, "waits for TestContainer_WaitFor_child, which failed to start")
+	}
+	if !found {
+		t.Errorf("expected dependency failure, got: %v", ft.failures)
+	}
+}
@@ -0,0 +1,138 @@
+package testingdock
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Stop stops the running container, it is killed if it does not stop within
+// the timeout. With RestartChildren the children are stopped first. The exit
+// is expected, so it is not reported as crash, and the container is still
+// removed on close.
+func (c *Container) Stop(ctx context.Context, timeout time.Duration) error {
+	if c.restartChildren {
+		if err := forEach(c.children, func(cc *Container) error {
+			return cc.Stop(ctx, timeout)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := c.expectExit(func() error {
+		return c.cli.ContainerStop(ctx, c.ID, &timeout)
+	}); err != nil {
+		return err
+	}
+	c.setStopped(true)
+	printf("(contrl) %-25s (%s) - container stopped", c.Name, c.ID)
+	return nil
+}
+
+// Start starts the stopped container again and blocks until its health check
+// succeeds. With RestartChildren the children are started afterwards.
+func (c *Container) Start(ctx context.Context) error {
+	if err := c.cli.ContainerStart(ctx, c.ID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+	c.setStopped(false)
+	printf("(contrl) %-25s (%s) - container started", c.Name, c.ID)
+
+	if !c.job {
+		if err := c.waitHealthy(ctx); err != nil {
+			return err
+		}
+	}
+
+	if c.restartChildren {
+		return forEach(c.children, func(cc *Container) error {
+			return cc.Start(ctx)
+		})
+	}
+	return nil
+}
+
+// Restart stops the container, see Stop, and starts it again, see Start.
+func (c *Container) Restart(ctx context.Context, timeout time.Duration) error {
+	if err := c.Stop(ctx, timeout); err != nil {
+		return err
+	}
+	return c.Start(ctx)
+}
+
+// Kill sends the signal to the container, the zero value is SIGKILL, and
+// waits up to the stop timeout for the container to exit. The exit is
+// expected, so it is not reported as crash. With RestartChildren the children
+// are stopped first.
+func (c *Container) Kill(ctx context.Context, signal string) error {
+	if c.restartChildren {
+		if err := forEach(c.children, func(cc *Container) error {
+			return cc.Stop(ctx, c.stopTimeout)
+		}); err != nil {
+			return err
+		}
+	}
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+
+	exited := false
+	if err := c.expectExit(func() error {
+		if err := c.cli.ContainerKill(ctx, c.ID, signal); err != nil {
+			return err
+		}
+		// the die event has to happen inside the expected time frame,
+		// but the container may ignore the signal
+		wctx, cancel := context.WithTimeout(ctx, c.stopTimeout)
+		defer cancel()
+		if _, err := c.cli.ContainerWait(wctx, c.ID); err != nil && wctx.Err() == nil {
+			return err
+		}
+		exited = wctx.Err() == nil
+		return nil
+	}); err != nil {
+		return err
+	}
+	if exited {
+		c.setStopped(true)
+	}
+	printf("(contrl) %-25s (%s) - container killed with signal %s", c.Name, c.ID, signal)
+	return nil
+}
+
+// Pause freezes all processes of the container.
+func (c *Container) Pause(ctx context.Context) error {
+	if err := c.cli.ContainerPause(ctx, c.ID); err != nil {
+		return err
+	}
+	c.setPaused(true)
+	printf("(contrl) %-25s (%s) - container paused", c.Name, c.ID)
+	return nil
+}
+
+// Unpause resumes the processes of the paused container.
+func (c *Container) Unpause(ctx context.Context) error {
+	if err := c.cli.ContainerUnpause(ctx, c.ID); err != nil {
+		return err
+	}
+	c.setPaused(false)
+	printf("(contrl) %-25s (%s) - container unpaused", c.Name, c.ID)
+	return nil
+}
+
+func (c *Container) setStopped(stopped bool) {
+	c.mu.Lock()
+	c.stopped = stopped
+	// the netem rules are gone with the network namespace
+	if stopped {
+		c.netem = nil
+	}
+	c.mu.Unlock()
+}
+
+func (c *Container) setPaused(paused bool) {
+	c.mu.Lock()
+	c.paused = paused
+	c.mu.Unlock()
+}
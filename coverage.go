@@ -0,0 +1,143 @@
+package testingdock
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// defaultCoverageDir is the directory inside the container, where coverage
+// data is written to, if CoverageOpts.Dir is not set.
+const defaultCoverageDir = "/tmp/testingdock-coverage"
+
+// CoverageOpts configures collecting Go coverage data from a container
+// running a binary built with `go build -cover`.
+//
+// GOCOVERDIR is set to Dir, which is created before the container starts.
+// On close the container is stopped gracefully, so the binary can write the
+// coverage data, which is then copied to HostDir/<container name>.
+type CoverageOpts struct {
+	// Dir inside the container, the default is /tmp/testingdock-coverage.
+	// It has to be an absolute path.
+	Dir string
+	// HostDir the coverage data is copied to, it is required.
+	HostDir string
+	// Profile is an optional path of a text coverage profile, which is
+	// merged from the coverage data via `go tool covdata textfmt`.
+	Profile string
+}
+
+// prepareCoverage creates the coverage directory inside the created
+// container, so it exists no matter the user the binary runs as.
+func (c *Container) prepareCoverage(ctx context.Context) error {
+	archive, err := coverageDirArchive(c.coverage.Dir)
+	if err != nil {
+		return err
+	}
+	return c.cli.CopyToContainer(ctx, c.ID, "/", archive, types.CopyToContainerOptions{})
+}
+
+// coverageDirArchive returns a tar archive containing the absolute
+// directory, relative to the root.
+func coverageDirArchive(dir string) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     strings.TrimPrefix(path.Clean(dir), "/") + "/",
+		Typeflag: tar.TypeDir,
+		Mode:     0777,
+		ModTime:  time.Now(),
+	}); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// collectCoverage copies the coverage data out of the stopped container
+// and merges it into a text profile, if requested.
+func (c *Container) collectCoverage(ctx context.Context) error {
+	reader, _, err := c.cli.CopyFromContainer(ctx, c.ID, c.coverage.Dir)
+	if err != nil {
+		return err
+	}
+	defer reader.Close() // nolint: errcheck
+
+	dir := filepath.Join(c.coverage.HostDir, c.Name)
+	files, err := extractCoverage(reader, dir)
+	if err != nil {
+		return err
+	}
+	printf("(cancel) %-25s (%s) - copied %d coverage data files to: %s", c.Name, c.ID, files, dir)
+
+	if c.coverage.Profile == "" {
+		return nil
+	}
+	if err = mergeCoverage(dir, c.coverage.Profile); err != nil {
+		return err
+	}
+	printf("(cancel) %-25s (%s) - coverage profile written to: %s", c.Name, c.ID, c.coverage.Profile)
+	return nil
+}
+
+// extractCoverage writes the regular files of the tar archive into the
+// directory. Returns the number of files.
+func extractCoverage(r io.Reader, dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	files := 0
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		// the coverage data files are flat
+		if err = writeFile(filepath.Join(dir, path.Base(hdr.Name)), tr); err != nil {
+			return files, err
+		}
+		files++
+	}
+}
+
+// mergeCoverage merges the coverage data in the directory into a text
+// profile.
+func mergeCoverage(dir, profile string) error {
+	out, err := exec.Command("go", "tool", "covdata", "textfmt", "-i="+dir, "-o="+profile).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("coverage profile merge failure: %s: %s", err, out)
+	}
+	return nil
+}
+
+func writeFile(name string, r io.Reader) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close() // nolint: errcheck
+		return err
+	}
+	return f.Close()
+}
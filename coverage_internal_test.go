@@ -0,0 +1,126 @@
+package testingdock
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCoverageDirArchive(t *testing.T) {
+	cases := map[string]string{
+		"/tmp/testingdock-coverage":  "tmp/testingdock-coverage/",
+		"/tmp/testingdock-coverage/": "tmp/testingdock-coverage/",
+		"/cover":                     "cover/",
+		"//var/./lib/../cover":       "var/cover/",
+	}
+	for dir, exp := range cases {
+		archive, err := coverageDirArchive(dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		hdr, err := tar.NewReader(archive).Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if hdr.Name != exp || hdr.Typeflag != tar.TypeDir {
+			t.Errorf("wrong entry for %s, expected directory %s but got %s (%c)", dir, exp, hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+func TestExtractCoverage(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "testingdock-coverage/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "testingdock-coverage/covmeta.1", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		{Name: "testingdock-coverage/covcounters.1.2.3", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := io.WriteString(tw, "data"); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "TestExtractCoverage")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	files, err := extractCoverage(&buf, filepath.Join(dir, "container"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if files != 2 {
+		t.Errorf("wrong number of files: %d", files)
+	}
+	for _, name := range []string{"covmeta.1", "covcounters.1.2.3"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "container", name))
+		if err != nil || string(data) != "data" {
+			t.Errorf("file %s not extracted: %v", name, err)
+		}
+	}
+}
+
+func TestMergeCoverage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestMergeCoverage")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	// coverage data of a binary built with go build -cover, go 1.20 or newer
+	files := map[string]string{
+		"go.mod":  "module covered\n\ngo 1.20\n",
+		"main.go": "package main\n\nfunc main() {\n\tprintln(\"covered\")\n}\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	build := exec.Command("go", "build", "-cover", "-o", "covered", ".")
+	build.Dir = dir
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Skipf("go build -cover not supported: %s: %s", err, out)
+	}
+	data := filepath.Join(dir, "data")
+	if err = os.Mkdir(data, 0755); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	run := exec.Command(filepath.Join(dir, "covered"))
+	run.Env = append(os.Environ(), "GOCOVERDIR="+data)
+	if out, err := run.CombinedOutput(); err != nil {
+		t.Fatalf("unexpected error: %s: %s", err, out)
+	}
+
+	profile := filepath.Join(dir, "cover.out")
+	if err = mergeCoverage(data, profile); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	content, err := ioutil.ReadFile(profile)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(string(content), "mode: ") || !strings.Contains(string(content), "covered/main.go") {
+		t.Errorf("wrong coverage profile:\n%s", content)
+	}
+
+	if err = mergeCoverage(filepath.Join(dir, "missing"), profile); err == nil {
+		t.Error("expected error for missing coverage data")
+	}
+}
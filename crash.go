@@ -0,0 +1,197 @@
+package testingdock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// crashLogLines is the number of last log lines attached to a crash.
+const crashLogLines = 20
+
+// Crash describes an unexpected exit of a container, which happened after
+// it passed its health check, e.g. because it was OOM-killed.
+type Crash struct {
+	Container string
+	ID        string
+	Time      time.Time
+	ExitCode  int
+	OOMKilled bool
+	// Signal is set if the container was killed from outside
+	Signal string
+	// Logs contains the last lines of the container output
+	Logs []string
+}
+
+// Error implements error interface.
+func (c *Crash) Error() string {
+	msg := fmt.Sprintf("container %s (%s) died unexpectedly with exit code %d", c.Container, c.ID, c.ExitCode)
+	if c.OOMKilled {
+		msg += ", OOM killed"
+	}
+	if c.Signal != "" {
+		msg += ", killed with signal " + c.Signal
+	}
+	if len(c.Logs) > 0 {
+		msg += ", last log lines:\n" + strings.Join(c.Logs, "\n")
+	}
+	return msg
+}
+
+// Err returns the first unexpected container exit since the suite was
+// started, or nil if all containers are alive.
+func (s *Suite) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.crashes) == 0 {
+		return nil
+	}
+	return s.crashes[0]
+}
+
+// reportCrashes fails the owning test for every crash, which was not
+// reported yet.
+func (s *Suite) reportCrashes() {
+	s.mu.Lock()
+	crashes := s.crashes[s.reported:]
+	s.reported = len(s.crashes)
+	s.mu.Unlock()
+
+	for _, crash := range crashes {
+		s.t.Errorf("%s", crash.Error())
+	}
+}
+
+// handleEvent records oom and kill events and turns unexpected die events
+// into crashes.
+func (s *Suite) handleEvent(ctx context.Context, e Event) {
+	c := s.ContainerByName(e.Container)
+	if c == nil {
+		return
+	}
+
+	switch e.Type {
+	case EventOOM:
+		c.mu.Lock()
+		c.oomKilled = true
+		c.mu.Unlock()
+	case EventKill:
+		c.mu.Lock()
+		c.signal = e.Signal
+		c.mu.Unlock()
+	case EventDie:
+		c.mu.Lock()
+		expected := c.exitExpected(e.Time)
+		crash := &Crash{
+			Container: c.Name,
+			ID:        e.ContainerID,
+			Time:      e.Time,
+			ExitCode:  e.ExitCode,
+			OOMKilled: c.oomKilled,
+			Signal:    c.signal,
+		}
+		c.oomKilled, c.signal = false, ""
+		// the netem rules are gone with the network namespace, expected
+		// exits clear them synchronously
+		if !expected {
+			c.netem = nil
+		}
+		c.mu.Unlock()
+
+		if expected {
+			return
+		}
+
+		cjson, err := s.cli.ContainerInspect(ctx, e.ContainerID)
+		if err == nil && cjson.State != nil {
+			crash.OOMKilled = crash.OOMKilled || cjson.State.OOMKilled
+		}
+		if crash.Logs, err = logLines(ctx, s.cli, e.ContainerID, c.ccfg.Tty, types.ContainerLogsOptions{
+			Tail: strconv.Itoa(crashLogLines),
+		}); err != nil {
+			printf("(crash ) %-25s (%s) - container logs failure: %s", c.Name, e.ContainerID, err)
+		}
+
+		s.mu.Lock()
+		s.crashes = append(s.crashes, crash)
+		s.mu.Unlock()
+
+		printf("(crash ) %-25s (%s) - %s", c.Name, e.ContainerID, crash.Error())
+
+		if c.restartOnCrash {
+			if err := s.cli.ContainerStart(ctx, e.ContainerID, types.ContainerStartOptions{}); err != nil {
+				printf("(crash ) %-25s (%s) - container restart failure: %s", c.Name, e.ContainerID, err)
+				return
+			}
+			printf("(crash ) %-25s (%s) - container restarted", c.Name, e.ContainerID)
+		}
+	}
+}
+
+// logLines returns the lines of the container output, both stdout and
+// stderr, limited by the Since and Tail options.
+func logLines(ctx context.Context, cli *client.Client, id string, tty bool, opts types.ContainerLogsOptions) ([]string, error) {
+	opts.ShowStdout, opts.ShowStderr = true, true
+
+	reader, err := cli.ContainerLogs(ctx, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close() // nolint: errcheck
+
+	// without a TTY docker multiplexes stdout and stderr into one stream
+	var buf bytes.Buffer
+	if tty {
+		_, err = io.Copy(&buf, reader)
+	} else {
+		_, err = stdcopy.StdCopy(&buf, &buf, reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		if line := scanner.Text(); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// expectExit marks the time frame in which the container is stopped on
+// purpose, e.g. during a reset, so die events are not reported as crashes.
+func (c *Container) expectExit(fn func() error) error {
+	c.mu.Lock()
+	c.expectedFrom, c.expectedTo = time.Now(), time.Time{}
+	c.mu.Unlock()
+
+	err := fn()
+
+	c.mu.Lock()
+	c.expectedTo = time.Now()
+	c.mu.Unlock()
+	return err
+}
+
+// exitExpected returns true if the container was stopped on purpose at
+// the given time. Must be called with c.mu held.
+func (c *Container) exitExpected(at time.Time) bool {
+	if c.expectedFrom.IsZero() {
+		return false
+	}
+	// still in progress or the event happened in the time frame,
+	// the second of slack accounts for clock differences with the daemon
+	return c.expectedTo.IsZero() || !at.Before(c.expectedFrom.Add(-time.Second)) && !at.After(c.expectedTo.Add(time.Second))
+}
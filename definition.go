@@ -0,0 +1,529 @@
+package testingdock
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"gopkg.in/yaml.v3"
+)
+
+// Definition is a declarative description of a suite, which can be shared
+// with teams not using Go. It is loaded from YAML or JSON with LoadDefinition
+// or LoadSuite, the format is described by testingdock.schema.json, e.g.:
+//  name: billing
+//  containers:
+//    - name: billing_postgres
+//      image: postgres:9.6
+//      ports: ["5432"]
+//      health_check:
+//        log: database system is ready to accept connections
+//    - name: billing_app
+//      image: billing:latest
+//      after: billing_postgres
+//      ports: ["8080"]
+//      health_check:
+//        http: {port: "8080", path: /health}
+//      reset: recreate
+type Definition struct {
+	// Name of the suite
+	Name    string            `yaml:"name" json:"name"`
+	Network NetworkDefinition `yaml:"network" json:"network"`
+	// Containers in any order, the tree is defined by ContainerDefinition.After
+	Containers []ContainerDefinition `yaml:"containers" json:"containers"`
+}
+
+// NetworkDefinition describes the network of a suite, see NetworkOpts.
+type NetworkDefinition struct {
+	// Name of the network, the default is the suite name
+	Name   string `yaml:"name" json:"name,omitempty"`
+	Subnet string `yaml:"subnet" json:"subnet,omitempty"`
+}
+
+// ContainerDefinition describes a container, see ContainerOpts.
+type ContainerDefinition struct {
+	Name       string            `yaml:"name" json:"name"`
+	Image      string            `yaml:"image" json:"image"`
+	Command    []string          `yaml:"command" json:"command,omitempty"`
+	Entrypoint []string          `yaml:"entrypoint" json:"entrypoint,omitempty"`
+	Env        map[string]string `yaml:"env" json:"env,omitempty"`
+	// Ports in the format of docker run -p, a port without host port is
+	// published on a random host port
+	Ports   []string           `yaml:"ports" json:"ports,omitempty"`
+	Volumes []VolumeDefinition `yaml:"volumes" json:"volumes,omitempty"`
+	Tmpfs   []string           `yaml:"tmpfs" json:"tmpfs,omitempty"`
+	Aliases []string           `yaml:"aliases" json:"aliases,omitempty"`
+	// After is the name of the container this one is started after, the
+	// default is the network, see Container.After
+	After string `yaml:"after" json:"after,omitempty"`
+	// WaitFor are names of containers in other branches of the tree, see
+	// Container.WaitFor
+	WaitFor     []string               `yaml:"wait_for" json:"wait_for,omitempty"`
+	Job         bool                   `yaml:"job" json:"job,omitempty"`
+	HealthCheck *HealthCheckDefinition `yaml:"health_check" json:"health_check,omitempty"`
+	// Reset is restart, recreate, snapshot or the name of a ResetFunc
+	// registered with RegisterReset, the default is restart
+	Reset string `yaml:"reset" json:"reset,omitempty"`
+	// Limits is one of the presets small, medium or large
+	Limits      string        `yaml:"limits" json:"limits,omitempty"`
+	StopSignal  string        `yaml:"stop_signal" json:"stop_signal,omitempty"`
+	StopTimeout time.Duration `yaml:"stop_timeout" json:"stop_timeout,omitempty"`
+	Reuse       bool          `yaml:"reuse" json:"reuse,omitempty"`
+}
+
+// VolumeDefinition describes a volume managed by testingdock, see VolumeOpts.
+type VolumeDefinition struct {
+	Name     string `yaml:"name" json:"name,omitempty"`
+	Target   string `yaml:"target" json:"target"`
+	ReadOnly bool   `yaml:"read_only" json:"read_only,omitempty"`
+	// HostDir is resolved relative to the definition file
+	HostDir string `yaml:"host_dir" json:"host_dir,omitempty"`
+}
+
+// HealthCheckDefinition describes the health check of a container, exactly
+// one of HTTP, TCP, Exec, Log and Custom has to be set.
+type HealthCheckDefinition struct {
+	HTTP *HTTPCheckDefinition `yaml:"http" json:"http,omitempty"`
+	// TCP is the container port, see HealthCheckTCP
+	TCP string `yaml:"tcp" json:"tcp,omitempty"`
+	// Exec is the command, see HealthCheckExec
+	Exec []string `yaml:"exec" json:"exec,omitempty"`
+	// Log is the regular expression, see HealthCheckLog
+	Log string `yaml:"log" json:"log,omitempty"`
+	// Custom is the name of a health check registered with
+	// RegisterHealthCheck
+	Custom  string        `yaml:"custom" json:"custom,omitempty"`
+	Timeout time.Duration `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+// HTTPCheckDefinition describes a HTTP health check, either the URL or the
+// container port and path have to be set.
+type HTTPCheckDefinition struct {
+	URL  string `yaml:"url" json:"url,omitempty"`
+	Port string `yaml:"port" json:"port,omitempty"`
+	Path string `yaml:"path" json:"path,omitempty"`
+}
+
+var (
+	customMu           sync.Mutex
+	customHealthChecks = make(map[string]HealthCheckFunc)
+	customResets       = make(map[string]ResetFunc)
+)
+
+// RegisterHealthCheck registers a health check, which is referenced by name
+// as custom health check in definitions. It has to be registered before the
+// definition is loaded, e.g. in TestMain.
+func RegisterHealthCheck(name string, fn HealthCheckFunc) {
+	customMu.Lock()
+	defer customMu.Unlock()
+	customHealthChecks[name] = fn
+}
+
+// RegisterReset registers a ResetFunc, which is referenced by name as reset
+// strategy in definitions. It has to be registered before the definition is
+// loaded, e.g. in TestMain.
+func RegisterReset(name string, fn ResetFunc) {
+	customMu.Lock()
+	defer customMu.Unlock()
+	customResets[name] = fn
+}
+
+func customHealthCheck(name string) (HealthCheckFunc, bool) {
+	customMu.Lock()
+	defer customMu.Unlock()
+	fn, ok := customHealthChecks[name]
+	return fn, ok
+}
+
+func customReset(name string) (ResetFunc, bool) {
+	customMu.Lock()
+	defer customMu.Unlock()
+	fn, ok := customResets[name]
+	return fn, ok
+}
+
+var limitPresets = map[string]Limits{
+	"small":  LimitsSmall,
+	"medium": LimitsMedium,
+	"large":  LimitsLarge,
+}
+
+// DefinitionError is an invalid value of a definition.
+type DefinitionError struct {
+	Line int
+	// Path of the value, e.g. containers[1].health_check
+	Path    string
+	Message string
+}
+
+// Error implements error interface.
+func (e DefinitionError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Path, e.Message)
+}
+
+// DefinitionErrors are all invalid values of a definition.
+type DefinitionErrors []DefinitionError
+
+// Error implements error interface.
+func (e DefinitionErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// LoadSuite loads the definition file and returns the suite with its network
+// and containers, see LoadDefinition and Definition.Suite.
+func LoadSuite(t testing.TB, path string, opts SuiteOpts) (*Suite, bool) {
+	d, err := LoadDefinition(path)
+	if err != nil {
+		t.Fatalf("suite definition %s loading failure: %s", path, err.Error())
+	}
+	return d.Suite(t, opts)
+}
+
+// LoadDefinition reads and validates a definition file, relative host
+// directories of volumes are resolved relative to its directory.
+func LoadDefinition(path string) (*Definition, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint: errcheck
+
+	d, err := ParseDefinition(f)
+	if err != nil {
+		return nil, err
+	}
+	for i := range d.Containers {
+		for j, v := range d.Containers[i].Volumes {
+			if v.HostDir != "" && !filepath.IsAbs(v.HostDir) {
+				d.Containers[i].Volumes[j].HostDir = filepath.Join(filepath.Dir(path), v.HostDir)
+			}
+		}
+	}
+	return d, nil
+}
+
+// ParseDefinition parses and validates a definition in YAML or JSON. Invalid
+// values are reported as DefinitionErrors.
+func ParseDefinition(r io.Reader) (*Definition, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var root yaml.Node
+	if err = yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var d Definition
+	if err = dec.Decode(&d); err != nil {
+		return nil, err
+	}
+
+	lines := make(map[string]int)
+	nodeLines(&root, "", root.Line, lines)
+	if errs := d.validate(lines); len(errs) > 0 {
+		return nil, errs
+	}
+	return &d, nil
+}
+
+// nodeLines records the line of every value in the document by its path,
+// e.g. containers[1].health_check, values of mappings have the line of
+// their key.
+func nodeLines(n *yaml.Node, path string, line int, lines map[string]int) {
+	lines[path] = line
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			nodeLines(c, path, c.Line, lines)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			nodeLines(c, path+"["+strconv.Itoa(i)+"]", c.Line, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+			nodeLines(n.Content[i+1], key, n.Content[i].Line, lines)
+		}
+	}
+}
+
+// definitionErrors collects the validation errors of a definition.
+type definitionErrors struct {
+	lines map[string]int
+	errs  DefinitionErrors
+}
+
+// add adds an error for the value at the path, its line is the line of the
+// closest value present in the document.
+func (e *definitionErrors) add(path, format string, args ...interface{}) {
+	line, p := 0, path
+	for {
+		if l, ok := e.lines[p]; ok {
+			line = l
+			break
+		}
+		i := strings.LastIndexAny(p, ".[")
+		if i < 0 {
+			line = e.lines[""]
+			break
+		}
+		p = p[:i]
+	}
+	e.errs = append(e.errs, DefinitionError{Line: line, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *Definition) validate(lines map[string]int) DefinitionErrors { // nolint: gocyclo
+	errs := &definitionErrors{lines: lines}
+	if d.Name == "" {
+		errs.add("name", "is required")
+	}
+	if len(d.Containers) == 0 {
+		errs.add("containers", "at least one container is required")
+	}
+
+	index := make(map[string]int, len(d.Containers))
+	for i, c := range d.Containers {
+		path := fmt.Sprintf("containers[%d]", i)
+		switch _, ok := index[c.Name]; {
+		case c.Name == "":
+			errs.add(path+".name", "is required")
+		case ok:
+			errs.add(path+".name", "duplicate container %s", c.Name)
+		default:
+			index[c.Name] = i
+		}
+		if c.Image == "" {
+			errs.add(path+".image", "is required")
+		}
+		for j, port := range c.Ports {
+			if _, err := nat.ParsePortSpec(port); err != nil {
+				errs.add(fmt.Sprintf("%s.ports[%d]", path, j), "%s", err)
+			}
+		}
+		for j, v := range c.Volumes {
+			if v.Target == "" {
+				errs.add(fmt.Sprintf("%s.volumes[%d].target", path, j), "is required")
+			}
+		}
+		if c.HealthCheck != nil {
+			c.HealthCheck.validate(path+".health_check", errs)
+		}
+		switch c.Reset {
+		case "", "restart", "recreate", "snapshot":
+		default:
+			if _, ok := customReset(c.Reset); !ok {
+				errs.add(path+".reset", "unknown reset strategy %s, neither restart, recreate, snapshot nor registered", c.Reset)
+			}
+		}
+		if _, ok := limitPresets[c.Limits]; c.Limits != "" && !ok {
+			errs.add(path+".limits", "unknown limits %s, expected small, medium or large", c.Limits)
+		}
+	}
+
+	// references and cycles of the start order
+	for i, c := range d.Containers {
+		path := fmt.Sprintf("containers[%d]", i)
+		if _, ok := index[c.After]; c.After != "" && !ok {
+			errs.add(path+".after", "undefined container %s", c.After)
+		}
+		for j, name := range c.WaitFor {
+			if _, ok := index[name]; !ok {
+				errs.add(fmt.Sprintf("%s.wait_for[%d]", path, j), "undefined container %s", name)
+			}
+		}
+	}
+	if len(errs.errs) == 0 {
+		state := make(map[string]int) // 1 visiting, 2 done
+		var visit func(name string) bool
+		visit = func(name string) bool {
+			switch state[name] {
+			case 1:
+				return false
+			case 2:
+				return true
+			}
+			state[name] = 1
+			c := d.Containers[index[name]]
+			for _, dep := range append([]string{c.After}, c.WaitFor...) {
+				if dep != "" && !visit(dep) {
+					return false
+				}
+			}
+			state[name] = 2
+			return true
+		}
+		for i, c := range d.Containers {
+			if !visit(c.Name) {
+				errs.add(fmt.Sprintf("containers[%d]", i), "container %s is part of a dependency cycle", c.Name)
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(errs.errs, func(i, j int) bool { return errs.errs[i].Line < errs.errs[j].Line })
+	return errs.errs
+}
+
+func (h *HealthCheckDefinition) validate(path string, errs *definitionErrors) {
+	var kinds int
+	if h.HTTP != nil {
+		kinds++
+		if (h.HTTP.URL == "") == (h.HTTP.Port == "") {
+			errs.add(path+".http", "either url or port has to be set")
+		}
+	}
+	if h.TCP != "" {
+		kinds++
+	}
+	if len(h.Exec) > 0 {
+		kinds++
+	}
+	if h.Log != "" {
+		kinds++
+		if _, err := regexp.Compile(h.Log); err != nil {
+			errs.add(path+".log", "%s", err)
+		}
+	}
+	if h.Custom != "" {
+		kinds++
+		if _, ok := customHealthCheck(h.Custom); !ok {
+			errs.add(path+".custom", "health check %s is not registered", h.Custom)
+		}
+	}
+	if kinds != 1 {
+		errs.add(path, "exactly one of http, tcp, exec, log and custom has to be set")
+	}
+}
+
+// Suite returns the suite of the definition with its network and
+// containers, see GetOrCreateSuite. Returns true if the suite was already
+// registered, it is returned unchanged then.
+func (d *Definition) Suite(t testing.TB, opts SuiteOpts) (*Suite, bool) {
+	s, ok := GetOrCreateSuite(t, d.Name, opts)
+	if ok {
+		return s, true
+	}
+
+	name := d.Network.Name
+	if name == "" {
+		name = d.Name
+	}
+	n := s.Network(NetworkOpts{Name: name, Subnet: d.Network.Subnet})
+
+	containers := make(map[string]*Container, len(d.Containers))
+	for _, cd := range d.Containers {
+		containers[cd.Name] = s.Container(cd.containerOpts())
+	}
+	for _, cd := range d.Containers {
+		c := containers[cd.Name]
+		if cd.After == "" {
+			n.After(c)
+		} else {
+			containers[cd.After].After(c)
+		}
+		for _, name := range cd.WaitFor {
+			c.WaitFor(containers[name])
+		}
+	}
+	return s, false
+}
+
+// containerOpts converts the validated definition to container options.
+func (cd ContainerDefinition) containerOpts() ContainerOpts {
+	var env []string
+	for _, key := range sortedKeys(cd.Env) {
+		env = append(env, key+"="+cd.Env[key])
+	}
+	// validated before
+	exposed, bindings, _ := nat.ParsePortSpecs(cd.Ports)
+
+	opts := ContainerOpts{
+		Name: cd.Name,
+		Config: &container.Config{
+			Image:        cd.Image,
+			Cmd:          cd.Command,
+			Entrypoint:   cd.Entrypoint,
+			Env:          env,
+			ExposedPorts: exposed,
+		},
+		HostConfig: &container.HostConfig{
+			PortBindings: bindings,
+		},
+		Tmpfs:       cd.Tmpfs,
+		Aliases:     cd.Aliases,
+		Job:         cd.Job,
+		StopSignal:  cd.StopSignal,
+		StopTimeout: cd.StopTimeout,
+		Reuse:       cd.Reuse,
+		Limits:      limitPresets[cd.Limits],
+	}
+	for _, v := range cd.Volumes {
+		opts.Volumes = append(opts.Volumes, VolumeOpts{Name: v.Name, Target: v.Target, ReadOnly: v.ReadOnly, HostDir: v.HostDir})
+	}
+	if h := cd.HealthCheck; h != nil {
+		opts.HealthCheck = h.healthCheck()
+		opts.HealthCheckTimeout = h.Timeout
+	}
+	switch cd.Reset {
+	case "", "restart":
+	case "recreate":
+		opts.Reset = ResetRecreate()
+	case "snapshot":
+		opts.Snapshot = true
+	default:
+		opts.Reset, _ = customReset(cd.Reset)
+	}
+	return opts
+}
+
+// healthCheck returns the health check function of the validated definition.
+func (h *HealthCheckDefinition) healthCheck() HealthCheckFunc {
+	switch {
+	case h.HTTP != nil && h.HTTP.URL != "":
+		return HealthCheckHTTP(h.HTTP.URL)
+	case h.HTTP != nil:
+		return func(ctx context.Context, c *Container) error {
+			addr, err := c.HostAddr(ctx, h.HTTP.Port)
+			if err != nil {
+				return err
+			}
+			return HealthCheckHTTP("http://"+addr+h.HTTP.Path)(ctx, c)
+		}
+	case h.TCP != "":
+		return HealthCheckTCP(h.TCP)
+	case len(h.Exec) > 0:
+		return HealthCheckExec(h.Exec...)
+	case h.Log != "":
+		return HealthCheckLog(h.Log)
+	default:
+		fn, _ := customHealthCheck(h.Custom)
+		return fn
+	}
+}
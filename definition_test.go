@@ -0,0 +1,202 @@
+package testingdock_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/piotrkowalczuk/testingdock"
+)
+
+func TestParseDefinition(t *testing.T) {
+	testingdock.RegisterHealthCheck("TestParseDefinition", testingdock.HealthCheckCustom(func() error { return nil }))
+
+	yaml := `
+name: billing
+network:
+  subnet: 172.29.0.0/16
+containers:
+  - name: billing_app
+    image: billing:latest
+    after: billing_postgres
+    ports: [8080]
+    health_check:
+      http: {port: "8080", path: /health}
+      timeout: 1m
+    reset: recreate
+  - name: billing_postgres
+    image: postgres:9.6
+    env:
+      POSTGRES_PASSWORD: secret
+    health_check:
+      custom: TestParseDefinition
+    limits: small
+`
+	json := `{
+  "name": "billing",
+  "network": {"subnet": "172.29.0.0/16"},
+  "containers": [
+    {
+      "name": "billing_app",
+      "image": "billing:latest",
+      "after": "billing_postgres",
+      "ports": ["8080"],
+      "health_check": {"http": {"port": "8080", "path": "/health"}, "timeout": "1m"},
+      "reset": "recreate"
+    },
+    {
+      "name": "billing_postgres",
+      "image": "postgres:9.6",
+      "env": {"POSTGRES_PASSWORD": "secret"},
+      "health_check": {"custom": "TestParseDefinition"},
+      "limits": "small"
+    }
+  ]
+}`
+
+	expected := &testingdock.Definition{
+		Name:    "billing",
+		Network: testingdock.NetworkDefinition{Subnet: "172.29.0.0/16"},
+		Containers: []testingdock.ContainerDefinition{
+			{
+				Name:  "billing_app",
+				Image: "billing:latest",
+				After: "billing_postgres",
+				Ports: []string{"8080"},
+				HealthCheck: &testingdock.HealthCheckDefinition{
+					HTTP:    &testingdock.HTTPCheckDefinition{Port: "8080", Path: "/health"},
+					Timeout: time.Minute,
+				},
+				Reset: "recreate",
+			},
+			{
+				Name:        "billing_postgres",
+				Image:       "postgres:9.6",
+				Env:         map[string]string{"POSTGRES_PASSWORD": "secret"},
+				HealthCheck: &testingdock.HealthCheckDefinition{Custom: "TestParseDefinition"},
+				Limits:      "small",
+			},
+		},
+	}
+
+	for format, given := range map[string]string{"yaml": yaml, "json": json} {
+		d, err := testingdock.ParseDefinition(strings.NewReader(given))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", format, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(d, expected) {
+			t.Errorf("%s: wrong definition, expected:\n%+v\nbut got:\n%+v", format, expected, d)
+		}
+	}
+}
+
+func TestParseDefinition_errors(t *testing.T) {
+	given := `name: invalid
+containers:
+  - name: a
+    image: alpine:3.6
+    after: b
+    health_check:
+      tcp: 5432
+      log: ready
+  - name: b
+    image: alpine:3.6
+    after: a
+  - name: c
+    ports: [99999999]
+    reset: sometimes
+    health_check:
+      custom: unknown
+`
+	_, err := testingdock.ParseDefinition(strings.NewReader(given))
+	errs, ok := err.(testingdock.DefinitionErrors)
+	if !ok {
+		t.Fatalf("expected definition errors, got %v", err)
+	}
+
+	expected := []string{
+		"line 6: containers[0].health_check: exactly one of http, tcp, exec, log and custom has to be set",
+		"line 12: containers[2].image: is required",
+		"line 13: containers[2].ports[0]:",
+		"line 14: containers[2].reset: unknown reset strategy sometimes",
+		"line 16: containers[2].health_check.custom: health check unknown is not registered",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got:\n%s", len(expected), errs)
+	}
+	for i, exp := range expected {
+		if !strings.HasPrefix(errs[i].Error(), exp) {
+			t.Errorf("wrong error, expected %q but got %q", exp, errs[i].Error())
+		}
+	}
+
+	// the cycle is only reported for an otherwise valid definition
+	cycle := "name: cycle\ncontainers:\n  - name: a\n    image: alpine:3.6\n    after: b\n  - name: b\n    image: alpine:3.6\n    wait_for: [a]\n"
+	_, err = testingdock.ParseDefinition(strings.NewReader(cycle))
+	if err == nil || !strings.Contains(err.Error(), "line 3: containers[0]: container a is part of a dependency cycle") {
+		t.Errorf("expected dependency cycle error, got %v", err)
+	}
+
+	// unknown fields are reported by the decoder
+	_, err = testingdock.ParseDefinition(strings.NewReader("name: x\ncontainers:\n  - name: a\n    imgae: alpine\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 4: field imgae not found") {
+		t.Errorf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestLoadSuite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLoadSuite")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	definition := `
+name: TestLoadSuite
+containers:
+  - name: TestLoadSuite_log
+    image: alpine:3.6
+    command: [sh, -c, "sleep 1 && echo ready && sleep 60"]
+    stop_signal: SIGKILL
+    health_check:
+      log: ^ready$
+  - name: TestLoadSuite_tcp
+    image: alpine:3.6
+    command: [nc, -lk, -p, "8080", -e, cat]
+    ports: ["8080"]
+    stop_signal: SIGKILL
+    after: TestLoadSuite_log
+    health_check:
+      tcp: "8080"
+  - name: TestLoadSuite_exec
+    image: alpine:3.6
+    command: [sh, -c, "sleep 1 && touch /tmp/ready && sleep 60"]
+    stop_signal: SIGKILL
+    wait_for: [TestLoadSuite_tcp]
+    health_check:
+      exec: [test, -f, /tmp/ready]
+`
+	path := filepath.Join(dir, "suite.yml")
+	if err = ioutil.WriteFile(path, []byte(definition), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s, ok := testingdock.LoadSuite(t, path, testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	s.Start(context.TODO())
+	defer s.Close()
+
+	for _, name := range []string{"TestLoadSuite_log", "TestLoadSuite_tcp", "TestLoadSuite_exec"} {
+		if s.ContainerByName(name) == nil {
+			t.Errorf("container %s not found", name)
+		}
+	}
+}
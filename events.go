@@ -0,0 +1,260 @@
+package testingdock
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// EventType is the type of a container or network lifecycle event.
+type EventType string
+
+const (
+	// EventCreate is emitted when a container is created.
+	EventCreate EventType = "create"
+	// EventStart is emitted when a container is started.
+	EventStart EventType = "start"
+	// EventHealthStatus is emitted when the docker health status of a
+	// container changes, see Event.Health.
+	EventHealthStatus EventType = "health_status"
+	// EventKill is emitted when a signal is sent to a container, see Event.Signal.
+	EventKill EventType = "kill"
+	// EventDie is emitted when a container exits, see Event.ExitCode.
+	EventDie EventType = "die"
+	// EventOOM is emitted when a container runs out of memory.
+	EventOOM EventType = "oom"
+	// EventConnect is emitted when a container is connected to the suite network.
+	EventConnect EventType = "connect"
+	// EventDisconnect is emitted when a container is disconnected from the suite network.
+	EventDisconnect EventType = "disconnect"
+)
+
+// Event is a lifecycle event of a container or the network of a suite.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Container is the name of the container, it is empty for network
+	// events of containers, which do not belong to the suite
+	Container   string
+	ContainerID string
+	// Network is only set for connect and disconnect events
+	Network   string
+	NetworkID string
+	// ExitCode is only set for die events
+	ExitCode int
+	// Signal is only set for kill events
+	Signal string
+	// Health is only set for health_status events
+	Health string
+}
+
+// eventsBuffer is the size of the channels returned by Suite.Events.
+const eventsBuffer = 64
+
+// Events returns a channel of lifecycle events of the suite containers and
+// network, as reported by the docker daemon. Events are delivered from
+// Suite.Start until the suite is closed or the context is cancelled, after
+// which the channel is closed. The channel has to be drained, otherwise
+// event delivery blocks.
+func (s *Suite) Events(ctx context.Context) <-chan Event {
+	ch := make(chan Event, eventsBuffer)
+
+	s.subMu.Lock()
+	s.subscribers = append(s.subscribers, &subscriber{ctx: ctx, ch: ch})
+	s.subMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.unsubscribe(ch)
+	}()
+	return ch
+}
+
+type subscriber struct {
+	ctx     context.Context
+	ch      chan Event
+	dropped int
+}
+
+// unsubscribe removes the subscriber and closes its channel.
+func (s *Suite) unsubscribe(ch chan Event) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	for i, sub := range s.subscribers {
+		if sub.ch == ch {
+			s.subscribers = append(s.subscribers[:i], s.subscribers[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+// watch subscribes to the docker events stream and dispatches the events
+// of the suite until the context is cancelled.
+func (s *Suite) watch(ctx context.Context) {
+	// network events do not carry labels, so the suite
+	// label is matched here instead of in the filter
+	args := filters.NewArgs()
+	args.Add("type", events.ContainerEventType)
+	args.Add("type", events.NetworkEventType)
+
+	// the stream is established asynchronously, so events emitted
+	// after watch returned but before the daemon accepted the request
+	// are replayed from the start time instead of being lost
+	msgs, errs := s.cli.Events(ctx, types.EventsOptions{
+		Since:   timestamp(time.Now()),
+		Filters: args,
+	})
+	go func() {
+		// network events only have the container ID
+		names := make(map[string]string)
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.closeSubscribers()
+				return
+			case err := <-errs:
+				if ctx.Err() == nil {
+					printf("(event ) %-25s (%-64s) - events stream failure: %s", s.name, "", err)
+				}
+				s.closeSubscribers()
+				return
+			case msg := <-msgs:
+				if e, ok := s.event(msg, names); ok {
+					s.dispatch(ctx, e)
+				}
+			}
+		}
+	}()
+}
+
+// event converts a docker events message into an Event, returns false if
+// it does not belong to the suite or is of no interest.
+func (s *Suite) event(msg events.Message, names map[string]string) (Event, bool) {
+	e := Event{Time: time.Unix(0, msg.TimeNano)}
+
+	switch msg.Type {
+	case events.ContainerEventType:
+		name, ok := s.eventContainer(msg)
+		if !ok {
+			return e, false
+		}
+		e.Container, e.ContainerID = name, msg.Actor.ID
+		names[e.ContainerID] = e.Container
+
+		// health status actions look like "health_status: healthy"
+		action := strings.SplitN(msg.Action, ":", 2)
+		e.Type = EventType(action[0])
+
+		switch e.Type {
+		case EventCreate, EventStart, EventOOM:
+		case EventHealthStatus:
+			if len(action) == 2 {
+				e.Health = strings.TrimSpace(action[1])
+			}
+		case EventKill:
+			e.Signal = msg.Actor.Attributes["signal"]
+		case EventDie:
+			e.ExitCode, _ = strconv.Atoi(msg.Actor.Attributes["exitCode"])
+		default:
+			return e, false
+		}
+	case events.NetworkEventType:
+		if s.network == nil || msg.Actor.Attributes["name"] != s.network.name {
+			return e, false
+		}
+		e.Type = EventType(msg.Action)
+		if e.Type != EventConnect && e.Type != EventDisconnect {
+			return e, false
+		}
+		e.Network, e.NetworkID = msg.Actor.Attributes["name"], msg.Actor.ID
+		e.ContainerID = msg.Actor.Attributes["container"]
+		e.Container = names[e.ContainerID]
+	default:
+		return e, false
+	}
+
+	return e, true
+}
+
+// eventContainer returns the name of the container of the suite, the event
+// is about. Containers attached with SuiteOpts.Attach have no labels of the
+// suite, they are matched by ID and named like in the suite, e.g. by their
+// docker compose service.
+func (s *Suite) eventContainer(msg events.Message) (string, bool) {
+	if msg.Actor.Attributes[SuiteLabel] == s.name && isOwnedByTestingdock(msg.Actor.Attributes) {
+		return msg.Actor.Attributes["name"], true
+	}
+	if !s.external || s.network == nil {
+		return "", false
+	}
+	for _, c := range s.network.containers() {
+		if c.ID == msg.Actor.ID {
+			return c.Name, true
+		}
+	}
+	return "", false
+}
+
+// dispatch logs the event, passes it to the crash detection and to all subscribers.
+func (s *Suite) dispatch(ctx context.Context, e Event) {
+	if Verbose {
+		if e.Network != "" {
+			printf("(event ) %-25s (%s) - %s: %s (%s)", e.Network, e.NetworkID, e.Type, e.Container, e.ContainerID)
+		} else {
+			printf("(event ) %-25s (%s) - %s", e.Container, e.ContainerID, e.Type)
+		}
+	}
+
+	s.handleEvent(ctx, e)
+
+	s.subMu.Lock()
+	subscribers := make([]*subscriber, len(s.subscribers))
+	copy(subscribers, s.subscribers)
+	s.subMu.Unlock()
+
+	for _, sub := range subscribers {
+		s.send(sub, e)
+	}
+}
+
+// send delivers the event unless the subscriber is gone or its buffer is
+// full, it never blocks.
+func (s *Suite) send(sub *subscriber, e Event) {
+	// hold the lock, so the channel is not closed concurrently
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	for _, cur := range s.subscribers {
+		if cur != sub {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped++
+			if sub.dropped == 1 || sub.dropped%eventsBuffer == 0 {
+				printf("(event ) %-25s (%-64s) - subscriber buffer full, %d events dropped", s.name, "", sub.dropped)
+			}
+		}
+		return
+	}
+}
+
+// closeSubscribers closes the channels of all subscribers.
+func (s *Suite) closeSubscribers() {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	for _, sub := range s.subscribers {
+		close(sub.ch)
+	}
+	s.subscribers = nil
+}
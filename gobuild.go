@@ -0,0 +1,207 @@
+package testingdock
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// GoBuildOpts is an option struct for building a Go main package into an
+// image in Suite.GoContainerOpts.
+type GoBuildOpts struct {
+	// Name of the container, the image is tagged as
+	// localhost/testingdock/<name>:latest
+	Name string
+	// Package is the main package to build, e.g. ./cmd/service
+	Package string
+	// Dir is the directory the package is built in, the default is the
+	// current working directory, which is the package directory in tests
+	Dir string
+	// BuildFlags are passed to go build, e.g. -cover or -tags
+	BuildFlags []string
+	// BaseImage the binary is copied into, the default is scratch
+	BaseImage string
+	// Args and Env of the binary
+	Args []string
+	Env  []string
+}
+
+// GoContainerOpts cross-compiles a Go main package for linux and builds a
+// minimal image with it via the docker build API, no Dockerfile required.
+// The image is removed on close.
+// The returned options run the binary in the suite network and can be
+// extended with health checks, reset functions and so on, before passing
+// them to Suite.Container.
+func (s *Suite) GoContainerOpts(ctx context.Context, opts GoBuildOpts) ContainerOpts {
+	if opts.BaseImage == "" {
+		opts.BaseImage = "scratch"
+	}
+	tag := "localhost/testingdock/" + strings.ToLower(opts.Name) + ":latest"
+
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		s.t.Fatalf("temporary directory creation failure: %s", err.Error())
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	// the binary has to match the architecture of the docker host
+	version, err := s.cli.ServerVersion(ctx)
+	if err != nil {
+		s.t.Fatalf("docker version failure: %s", err.Error())
+	}
+
+	now := time.Now()
+	bin := filepath.Join(dir, "app")
+	args := append([]string{"build", "-o", bin}, opts.BuildFlags...)
+	cmd := exec.Command("go", append(args, opts.Package)...)
+	cmd.Dir = opts.Dir
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+version.Arch, "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		s.t.Fatalf("go build failure of %s: %s: %s", opts.Package, err.Error(), out)
+	}
+	printf("(setup ) %-25s - go package %s built in %s", opts.Name, opts.Package, time.Since(now))
+
+	buildCtx, err := goBuildContext(bin, opts.BaseImage)
+	if err != nil {
+		s.t.Fatalf("image build context failure: %s", err.Error())
+	}
+
+	labels := createTestingLabel()
+	labels[SuiteLabel] = s.name
+	if err = imageBuild(ctx, s.cli, buildCtx, types.ImageBuildOptions{
+		Tags:        []string{tag},
+		Labels:      labels,
+		Remove:      true,
+		ForceRemove: true,
+	}); err != nil {
+		s.t.Fatalf("image build failure of %s: %s", tag, err.Error())
+	}
+	printf("(setup ) %-25s - image built: %s", opts.Name, tag)
+	s.addImage(tag)
+
+	return ContainerOpts{
+		Name: opts.Name,
+		Config: &container.Config{
+			Image: tag,
+			Cmd:   opts.Args,
+			Env:   opts.Env,
+		},
+	}
+}
+
+// addImage registers an image built for the suite, which is removed on
+// close.
+func (s *Suite) addImage(tag string) {
+	s.mu.Lock()
+	s.images = append(s.images, tag)
+	s.mu.Unlock()
+}
+
+// removeImages removes the images built for the suite, except for the ones
+// of containers kept for reuse.
+func (s *Suite) removeImages(ctx context.Context) error {
+	s.mu.Lock()
+	images := s.images
+	s.images = nil
+	s.mu.Unlock()
+
+	reused := make(map[string]bool)
+	if s.network != nil {
+		for _, c := range s.network.containers() {
+			reused[c.image] = reused[c.image] || c.reuse
+		}
+	}
+	for _, tag := range images {
+		if reused[tag] {
+			continue
+		}
+		if _, err := s.cli.ImageRemove(ctx, tag, types.ImageRemoveOptions{
+			Force:         true,
+			PruneChildren: true,
+		}); err != nil {
+			return err
+		}
+		printf("(cancel) %-25s - image removed: %s", s.name, tag)
+	}
+	return nil
+}
+
+// goBuildContext creates a tar build context with a generated Dockerfile,
+// which copies the binary into the base image.
+func goBuildContext(bin, baseImage string) (io.Reader, error) {
+	binary, err := ioutil.ReadFile(bin)
+	if err != nil {
+		return nil, err
+	}
+	dockerfile := fmt.Sprintf("FROM %s\nCOPY app /app\nENTRYPOINT [\"/app\"]\n", baseImage)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range []struct {
+		name string
+		mode int64
+		body []byte
+	}{
+		{name: "Dockerfile", mode: 0644, body: []byte(dockerfile)},
+		{name: "app", mode: 0755, body: binary},
+	} {
+		if err = tw.WriteHeader(&tar.Header{
+			Name:    f.name,
+			Mode:    f.mode,
+			Size:    int64(len(f.body)),
+			ModTime: time.Now(),
+		}); err != nil {
+			return nil, err
+		}
+		if _, err = tw.Write(f.body); err != nil {
+			return nil, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// imageBuild builds an image and waits for the build to finish. The build
+// output is only logged if Verbose is set.
+func imageBuild(ctx context.Context, cli *client.Client, buildCtx io.Reader, opts types.ImageBuildOptions) error {
+	res, err := cli.ImageBuild(ctx, buildCtx, opts)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close() // nolint: errcheck
+
+	// the response is a stream of json messages
+	dec := json.NewDecoder(res.Body)
+	for {
+		var msg struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err = dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("%s", msg.Error)
+		}
+		if line := strings.TrimSpace(msg.Stream); Verbose && line != "" {
+			printf("(build ) %-25s - %s", opts.Tags, line)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package testingdock_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/client"
+	"github.com/piotrkowalczuk/testingdock"
+)
+
+func TestSuite_GoContainerOpts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSuite_GoContainerOpts")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	files := map[string]string{
+		"go.mod":  "module hello\n",
+		"main.go": "package main\n\nimport \"os\"\n\nfunc main() {\n\tif len(os.Args) != 2 || os.Args[1] != \"hello\" {\n\t\tos.Exit(1)\n\t}\n}\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_GoContainerOpts", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	n := s.Network(testingdock.NetworkOpts{Name: "TestSuite_GoContainerOpts"})
+	opts := s.GoContainerOpts(context.TODO(), testingdock.GoBuildOpts{
+		Name:    "TestSuite_GoContainerOpts",
+		Package: ".",
+		Dir:     dir,
+		Args:    []string{"hello"},
+	})
+	// fails the start, unless the binary exits with code zero
+	opts.Job = true
+	c := s.Container(opts)
+	n.After(c)
+
+	s.Start(context.TODO())
+	if err = s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, _, err = cli.ImageInspectWithRaw(context.TODO(), c.Image); !client.IsErrImageNotFound(err) {
+		t.Errorf("image %s should be removed on close, got: %v", c.Image, err)
+	}
+}
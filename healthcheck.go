@@ -0,0 +1,116 @@
+package testingdock
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// HealthCheckTCP is a pre-implemented HealthCheckFunc which checks if a TCP
+// connection can be established to the host port the given container port
+// is published on, e.g. "5432/tcp" or just "5432".
+func HealthCheckTCP(port string) HealthCheckFunc {
+	return func(ctx context.Context, c *Container) error {
+		addr, err := c.HostAddr(ctx, port)
+		if err != nil {
+			return err
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HealthCheckExec is a pre-implemented HealthCheckFunc which runs the command
+// inside the container and checks if it exits with code zero, e.g.
+//  HealthCheckExec("pg_isready", "-U", "postgres")
+func HealthCheckExec(cmd ...string) HealthCheckFunc {
+	return func(ctx context.Context, c *Container) error {
+		code, out, err := c.exec(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("%s exited with code %d: %s", strings.Join(cmd, " "), code, strings.TrimSpace(out))
+		}
+		return nil
+	}
+}
+
+// HealthCheckLog is a pre-implemented HealthCheckFunc which checks if a line
+// logged since the container was started last matches the regular
+// expression, e.g. "database system is ready to accept connections".
+func HealthCheckLog(pattern string) HealthCheckFunc {
+	re, err := regexp.Compile(pattern)
+	return func(ctx context.Context, c *Container) error {
+		if err != nil {
+			return err
+		}
+		cjson, ierr := c.Inspect(ctx)
+		if ierr != nil {
+			return ierr
+		}
+		lines, lerr := logLines(ctx, c.cli, c.ID, cjson.Config.Tty, types.ContainerLogsOptions{
+			Since: cjson.State.StartedAt,
+		})
+		if lerr != nil {
+			return lerr
+		}
+		for _, line := range lines {
+			if re.MatchString(line) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no log line matches %s yet", pattern)
+	}
+}
+
+// exec runs the command inside the container and returns its exit code and
+// combined output.
+func (c *Container) exec(ctx context.Context, cmd []string) (int, string, error) {
+	cfg := types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+	exec, err := c.cli.ContainerExecCreate(ctx, c.ID, cfg)
+	if err != nil {
+		return 0, "", err
+	}
+	res, err := c.cli.ContainerExecAttach(ctx, exec.ID, cfg)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Close()
+
+	var buf bytes.Buffer
+	if _, err = stdcopy.StdCopy(&buf, &buf, res.Reader); err != nil {
+		return 0, "", err
+	}
+
+	// the exit code is set shortly after the output is closed
+	for {
+		inspect, err := c.cli.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return 0, "", err
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, buf.String(), nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, "", ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package testingdock
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net"
 	"strconv"
 	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
 )
 
 // printf just wraps fmt.Printf.
@@ -37,6 +44,17 @@ func randomPort(t testing.TB) int {
 	return l.Addr().(*net.TCPAddr).Port
 }
 
+const (
+	// imageLabel holds the original, not rewritten, image reference of a container.
+	imageLabel = "testingdock.image"
+	// hashLabel holds the configuration hash of a reusable container.
+	hashLabel = "testingdock.hash"
+)
+
+// SuiteLabel is the label, which holds the name of the suite a container,
+// network or image belongs to.
+const SuiteLabel = "testingdock.suite"
+
 // Check whether a map containing labels has the "owner=testingdock" label.
 func isOwnedByTestingdock(labels map[string]string) bool {
 	for key, value := range labels {
@@ -53,3 +71,63 @@ func createTestingLabel() map[string]string {
 	labels["owner"] = "testingdock"
 	return labels
 }
+
+// Copy a map of labels, so it can be modified without side effects.
+func copyLabels(labels map[string]string) map[string]string {
+	cp := make(map[string]string, len(labels))
+	for key, value := range labels {
+		cp[key] = value
+	}
+	return cp
+}
+
+// Check whether the container names returned by the docker API, which are
+// prefixed with a slash, contain exactly the given name.
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if n == "/"+name {
+			return true
+		}
+	}
+	return false
+}
+
+// Compute a hash of the container configuration. The host configuration
+// includes the network mode, so a changed network also changes the hash.
+func configHash(ccfg *container.Config, hcfg *container.HostConfig) (string, error) {
+	// maps are marshaled with sorted keys, so the output is stable
+	b, err := json.Marshal(struct {
+		Config     *container.Config
+		HostConfig *container.HostConfig
+	}{ccfg, hcfg})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// samePorts returns true if both port maps bind the same host ports.
+func samePorts(a, b nat.PortMap) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for port, bindings := range a {
+		other, ok := b[port]
+		if !ok || len(bindings) != len(other) {
+			return false
+		}
+		for i := range bindings {
+			if bindings[i].HostPort != other[i].HostPort {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Format the time as a unix timestamp with nanoseconds, as accepted by
+// the since and until options of the docker API.
+func timestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
@@ -0,0 +1,135 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+)
+
+// ContainerHook is the type of a container lifecycle hook. An error fails
+// the lifecycle step the hook is called in.
+type ContainerHook func(ctx context.Context, c *Container) error
+
+// NetworkHook is the type of a network lifecycle hook. An error fails
+// the lifecycle step the hook is called in.
+type NetworkHook func(ctx context.Context, n *Network) error
+
+// SuiteHook is the type of a suite lifecycle hook. An error fails
+// the lifecycle step the hook is called in.
+type SuiteHook func(ctx context.Context, s *Suite) error
+
+// ContainerHooks are called during the lifecycle of a container. Hooks
+// may be nil.
+type ContainerHooks struct {
+	// BeforeCreate is called before the container is created
+	BeforeCreate ContainerHook
+	// AfterCreate is called after the container is created but before
+	// it is started, e.g. to copy files into it
+	AfterCreate ContainerHook
+	// AfterStart is called after the container is started, for jobs
+	// after they finished
+	AfterStart ContainerHook
+	// AfterHealthy is called after the health check succeeded on start
+	AfterHealthy ContainerHook
+	// BeforeReset and AfterReset are called around the ResetFunc and
+	// the following health check
+	BeforeReset ContainerHook
+	AfterReset  ContainerHook
+	// BeforeStop is called before the container is stopped on close
+	BeforeStop ContainerHook
+	// AfterClose is called after the container is removed
+	AfterClose ContainerHook
+}
+
+// NetworkHooks are called during the lifecycle of a network. Hooks may be nil.
+type NetworkHooks struct {
+	// BeforeCreate and AfterCreate are called around the network creation,
+	// they are skipped if an existing network is reused
+	BeforeCreate NetworkHook
+	AfterCreate  NetworkHook
+	// AfterStart is called when the network is ready, before any
+	// containers are started
+	AfterStart NetworkHook
+	// AfterHealthy is called after all containers are started and healthy
+	AfterHealthy NetworkHook
+	// BeforeReset and AfterReset are called around the reset of all containers
+	BeforeReset NetworkHook
+	AfterReset  NetworkHook
+	// BeforeStop is called before the containers are stopped on close
+	BeforeStop NetworkHook
+	// AfterClose is called after the network is removed
+	AfterClose NetworkHook
+}
+
+// SuiteHooks are called during the lifecycle of a suite. Hooks may be nil.
+type SuiteHooks struct {
+	// BeforeCreate is called on start, before anything else
+	BeforeCreate SuiteHook
+	// AfterCreate is called once the suite watches the docker events,
+	// before the network is created
+	AfterCreate SuiteHook
+	// AfterStart and AfterHealthy are called after the network and all
+	// containers are started, which includes their health checks
+	AfterStart   SuiteHook
+	AfterHealthy SuiteHook
+	// BeforeReset and AfterReset are called around the reset of the network
+	BeforeReset SuiteHook
+	AfterReset  SuiteHook
+	// BeforeStop is called on close, before anything is stopped
+	BeforeStop SuiteHook
+	// AfterClose is called after everything is removed
+	AfterClose SuiteHook
+}
+
+// hook calls the container hook, if set.
+func (c *Container) hook(ctx context.Context, name string, fn ContainerHook) error {
+	if fn == nil {
+		return nil
+	}
+	if err := fn(ctx, c); err != nil {
+		return fmt.Errorf("container %s %s hook failure: %s", c.Name, name, err)
+	}
+	return nil
+}
+
+// mustHook calls the container hook, if set, and fails the test on error.
+func (c *Container) mustHook(ctx context.Context, name string, fn ContainerHook) {
+	if err := c.hook(ctx, name, fn); err != nil {
+		c.t.Fatalf("%s", err.Error())
+	}
+}
+
+// hook calls the network hook, if set.
+func (n *Network) hook(ctx context.Context, name string, fn NetworkHook) error {
+	if fn == nil {
+		return nil
+	}
+	if err := fn(ctx, n); err != nil {
+		return fmt.Errorf("network %s %s hook failure: %s", n.name, name, err)
+	}
+	return nil
+}
+
+// mustHook calls the network hook, if set, and fails the test on error.
+func (n *Network) mustHook(ctx context.Context, name string, fn NetworkHook) {
+	if err := n.hook(ctx, name, fn); err != nil {
+		n.t.Fatalf("%s", err.Error())
+	}
+}
+
+// hook calls the suite hook, if set.
+func (s *Suite) hook(ctx context.Context, name string, fn SuiteHook) error {
+	if fn == nil {
+		return nil
+	}
+	if err := fn(ctx, s); err != nil {
+		return fmt.Errorf("suite %s %s hook failure: %s", s.name, name, err)
+	}
+	return nil
+}
+
+// mustHook calls the suite hook, if set, and fails the test on error.
+func (s *Suite) mustHook(ctx context.Context, name string, fn SuiteHook) {
+	if err := s.hook(ctx, name, fn); err != nil {
+		s.t.Fatalf("%s", err.Error())
+	}
+}
@@ -0,0 +1,136 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// KeepMode controls whether containers and networks are kept alive after
+// the suite is closed, e.g. for post-mortem debugging.
+type KeepMode string
+
+const (
+	// KeepNever always tears the suite down on close, this is the default.
+	KeepNever KeepMode = "never"
+	// KeepOnFailure keeps the suite alive if the test that created it or
+	// any tracked test failed, see Suite.Track.
+	KeepOnFailure KeepMode = "on-failure"
+	// KeepAlways never tears the suite down on close.
+	KeepAlways KeepMode = "always"
+)
+
+// String implements flag.Value interface.
+func (k *KeepMode) String() string {
+	if k == nil || *k == "" {
+		return string(KeepNever)
+	}
+	return string(*k)
+}
+
+// Set implements flag.Value interface.
+func (k *KeepMode) Set(value string) error {
+	switch mode := KeepMode(value); mode {
+	case KeepNever, KeepOnFailure, KeepAlways:
+		*k = mode
+		return nil
+	default:
+		return fmt.Errorf("unknown keep mode %q, expected one of: %s, %s, %s", value, KeepNever, KeepOnFailure, KeepAlways)
+	}
+}
+
+// Keep controls whether suites are kept alive after close. It can be
+// overridden per suite in SuiteOpts.
+var Keep = KeepNever
+
+// keepMode returns the keep mode of the suite, falling back to the global one.
+func (s *Suite) keepMode() KeepMode {
+	if s.keep != "" {
+		return s.keep
+	}
+	if Keep == "" {
+		return KeepNever
+	}
+	return Keep
+}
+
+// shouldKeep returns true if the suite must not be torn down on close.
+func (s *Suite) shouldKeep() bool {
+	switch s.keepMode() {
+	case KeepAlways:
+		return true
+	case KeepOnFailure:
+		return s.Failed()
+	default:
+		return false
+	}
+}
+
+// Track registers a test using the suite, which did not create it, e.g. one
+// of the tests sharing it via GetOrCreateSuite, so its failure is reflected by
+// Suite.Failed.
+func (s *Suite) Track(t testing.TB) {
+	if t == nil || t == s.t {
+		return
+	}
+	s.mu.Lock()
+	s.tracked = append(s.tracked, t)
+	s.mu.Unlock()
+}
+
+// Failed returns true if the test, which created the suite, or any tracked
+// test failed.
+func (s *Suite) Failed() bool {
+	if s.t.Failed() {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tracked {
+		if t.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// printKept prints everything needed to inspect the containers kept alive.
+func (s *Suite) printKept(ctx context.Context) {
+	if s.network == nil {
+		return
+	}
+	printf("(keep  ) %-25s (%-64s) - suite kept alive, remove it with: docker network rm %s", s.network.name, s.network.id, s.network.name)
+
+	for _, c := range s.network.containers() {
+		if c.ID == "" {
+			continue
+		}
+		printf("(keep  ) %-25s (%s) - container kept alive, ports: %s", c.Name, c.ID, c.ports(ctx))
+		printf("(keep  ) %-25s (%s) - docker exec -it %s sh", c.Name, c.ID, c.Name)
+	}
+}
+
+// ports returns a human readable list of the published container ports.
+func (c *Container) ports(ctx context.Context) string {
+	cjson, err := c.Inspect(ctx)
+	if err != nil {
+		return fmt.Sprintf("unknown (%s)", err)
+	}
+	if cjson.NetworkSettings == nil {
+		return "none"
+	}
+
+	var ports []string
+	for port, bindings := range cjson.NetworkSettings.Ports {
+		for _, b := range bindings {
+			ports = append(ports, fmt.Sprintf("%s:%s->%s", b.HostIP, b.HostPort, port))
+		}
+	}
+	if len(ports) == 0 {
+		return "none"
+	}
+	sort.Strings(ports)
+	return strings.Join(ports, ", ")
+}
@@ -0,0 +1,51 @@
+package testingdock_test
+
+import (
+	"testing"
+
+	"github.com/piotrkowalczuk/testingdock"
+)
+
+func TestKeepMode_Set(t *testing.T) {
+	var k testingdock.KeepMode
+	if k.String() != "never" {
+		t.Errorf("zero value should be never, got %s", k.String())
+	}
+	for _, mode := range []string{"never", "on-failure", "always"} {
+		if err := k.Set(mode); err != nil {
+			t.Errorf("%s: unexpected error: %s", mode, err.Error())
+		}
+		if k.String() != mode {
+			t.Errorf("wrong mode, expected %s but got %s", mode, k.String())
+		}
+	}
+	if err := k.Set("sometimes"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+// failedT is a test, which failed.
+type failedT struct {
+	testing.TB
+}
+
+func (failedT) Failed() bool { return true }
+
+func TestSuite_Failed(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_Failed", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	if s.Failed() {
+		t.Fatal("suite should not be failed")
+	}
+
+	// a later test sharing the suite fails
+	shared, ok := testingdock.GetOrCreateSuite(failedT{TB: t}, "TestSuite_Failed", testingdock.SuiteOpts{})
+	if !ok || shared != s {
+		t.Fatal("suite should be shared")
+	}
+	if !s.Failed() {
+		t.Error("failure of the sharing test should be tracked")
+	}
+}
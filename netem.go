@@ -0,0 +1,195 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// NetEmImage is the image of the helper container, which applies the netem
+// rules, it has to contain the tc binary of iproute2. It is rewritten and
+// pulled like the images of the containers, see SuiteOpts.Rewrite.
+var NetEmImage = "gaiadocker/iproute2:latest"
+
+// NetEmRules describe the traffic control applied to all outgoing packets of
+// a container with Container.NetEm, see tc-netem(8). The zero value applies
+// no rules.
+type NetEmRules struct {
+	// Interface the rules are applied to, the default is eth0
+	Interface string
+	// Delay of every packet, Jitter randomly adds or subtracts up to the
+	// given duration
+	Delay  time.Duration
+	Jitter time.Duration
+	// Loss, Duplicate and Corrupt are the percentages of packets, which are
+	// dropped, duplicated or corrupted with a single bit error
+	Loss      float64
+	Duplicate float64
+	Corrupt   float64
+	// Rate limits the bandwidth, e.g. 1mbit or 100kbit
+	Rate string
+}
+
+// Args returns the tc arguments, which apply the rules.
+func (r NetEmRules) Args() []string {
+	args := []string{"qdisc", "replace", "dev", r.device(), "root", "netem"}
+	if r.Delay > 0 {
+		args = append(args, "delay", tcDuration(r.Delay))
+		if r.Jitter > 0 {
+			args = append(args, tcDuration(r.Jitter))
+		}
+	}
+	for _, p := range []struct {
+		name    string
+		percent float64
+	}{
+		{name: "loss", percent: r.Loss},
+		{name: "duplicate", percent: r.Duplicate},
+		{name: "corrupt", percent: r.Corrupt},
+	} {
+		if p.percent > 0 {
+			args = append(args, p.name, strconv.FormatFloat(p.percent, 'f', -1, 64)+"%")
+		}
+	}
+	if r.Rate != "" {
+		args = append(args, "rate", r.Rate)
+	}
+	return args
+}
+
+func (r NetEmRules) device() string {
+	if r.Interface == "" {
+		return "eth0"
+	}
+	return r.Interface
+}
+
+// tcDuration formats the duration in microseconds, the finest unit tc supports.
+func tcDuration(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Microsecond), 10) + "us"
+}
+
+// NetEm applies the traffic control rules to the network namespace of the
+// container, so they affect its traffic to other containers as well. The rules
+// replace the ones applied before and are cleared on Suite.Reset or when the
+// container stops, e.g. on Container.Restart.
+//
+// The rules are applied by a privileged helper container sharing the network
+// namespace, see NetEmImage.
+func (c *Container) NetEm(ctx context.Context, rules NetEmRules) error {
+	if err := c.tc(ctx, rules.Args()); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.netem = &rules
+	c.mu.Unlock()
+
+	printf("(fault ) %-25s (%s) - netem rules applied: %s", c.Name, c.ID, strings.Join(rules.Args()[5:], " "))
+	return nil
+}
+
+// ClearNetEm removes the traffic control rules applied by NetEm.
+func (c *Container) ClearNetEm(ctx context.Context) error {
+	c.mu.Lock()
+	rules, stopped := c.netem, c.stopped
+	c.mu.Unlock()
+	if rules == nil {
+		return nil
+	}
+
+	// the rules are gone with the network namespace of a stopped container
+	if !stopped {
+		if err := c.tc(ctx, []string{"qdisc", "del", "dev", rules.device(), "root"}); err != nil && !noQdisc(err) {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.netem = nil
+	c.mu.Unlock()
+
+	printf("(fault ) %-25s (%s) - netem rules cleared", c.Name, c.ID)
+	return nil
+}
+
+// noQdisc returns true if tc failed, because there is no qdisc to delete,
+// e.g. after the container was restarted.
+func noQdisc(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "No such file or directory") || strings.Contains(msg, "handle of zero")
+}
+
+// tc runs tc with the given arguments in a helper container sharing the
+// network namespace of the container.
+func (c *Container) tc(ctx context.Context, args []string) error {
+	image := NetEmImage
+	if c.suite != nil {
+		image = rewriteImage(c.suite.rewrite, image)
+	}
+	if err := c.pullImage(ctx, image, false); err != nil {
+		return err
+	}
+
+	name := c.Name + "_netem"
+	if err := removeHelper(ctx, c.cli, name); err != nil {
+		return err
+	}
+
+	labels := createTestingLabel()
+	if c.suite != nil {
+		labels[SuiteLabel] = c.suite.name
+	}
+	cont, err := c.cli.ContainerCreate(ctx, &container.Config{
+		Image:      image,
+		Entrypoint: []string{"tc"},
+		Cmd:        args,
+		Labels:     labels,
+	}, &container.HostConfig{
+		NetworkMode: container.NetworkMode("container:" + c.ID),
+		Privileged:  true,
+	}, nil, name)
+	if err != nil {
+		return err
+	}
+	defer c.cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{Force: true}) // nolint: errcheck
+
+	if err = c.cli.ContainerStart(ctx, cont.ID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+	code, err := c.cli.ContainerWait(ctx, cont.ID)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		logs, _ := logLines(ctx, c.cli, cont.ID, false, types.ContainerLogsOptions{})
+		return fmt.Errorf("tc %s exited with code %d: %s", strings.Join(args, " "), code, strings.Join(logs, "\n"))
+	}
+	return nil
+}
+
+// removeHelper removes a helper container left by a previous run.
+func removeHelper(ctx context.Context, cli *client.Client, name string) error {
+	containers, err := findContainerByName(ctx, cli, name)
+	if err != nil {
+		return err
+	}
+	for _, cont := range containers {
+		if !hasName(cont.Names, name) {
+			continue
+		}
+		if !isOwnedByTestingdock(cont.Labels) {
+			return fmt.Errorf("container with name %s already exists, but wasn't started by testingdock", name)
+		}
+		if err = cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
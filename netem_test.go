@@ -0,0 +1,130 @@
+package testingdock_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/piotrkowalczuk/testingdock"
+)
+
+func TestNetEmRules_Args(t *testing.T) {
+	cases := map[string]struct {
+		rules testingdock.NetEmRules
+		exp   string
+	}{
+		"empty": {
+			exp: "qdisc replace dev eth0 root netem",
+		},
+		"delay": {
+			rules: testingdock.NetEmRules{Delay: 100 * time.Millisecond, Jitter: 10 * time.Millisecond},
+			exp:   "qdisc replace dev eth0 root netem delay 100000us 10000us",
+		},
+		"all": {
+			rules: testingdock.NetEmRules{
+				Interface: "eth1",
+				Delay:     time.Millisecond,
+				Loss:      5,
+				Duplicate: 0.5,
+				Corrupt:   1,
+				Rate:      "1mbit",
+			},
+			exp: "qdisc replace dev eth1 root netem delay 1000us loss 5% duplicate 0.5% corrupt 1% rate 1mbit",
+		},
+	}
+
+	for hint, c := range cases {
+		t.Run(hint, func(t *testing.T) {
+			if got := strings.Join(c.rules.Args(), " "); got != c.exp {
+				t.Errorf("wrong args, expected:\n	%s\nbut got:\n	%s", c.exp, got)
+			}
+		})
+	}
+}
+
+func TestContainer_NetEm_rewrite(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	// records the images, without rewriting them
+	record := func(image string) (string, bool) {
+		mu.Lock()
+		seen = append(seen, image)
+		mu.Unlock()
+		return "", false
+	}
+	s, ok := testingdock.GetOrCreateSuite(t, "TestContainer_NetEm_rewrite", testingdock.SuiteOpts{
+		Rewrite: []testingdock.RewriteFunc{record},
+	})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestContainer_NetEm_rewrite",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		StopSignal: "SIGKILL",
+	})
+	s.Network(testingdock.NetworkOpts{Name: "TestContainer_NetEm_rewrite"}).After(c)
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	if err := c.NetEm(context.TODO(), testingdock.NetEmRules{Delay: time.Millisecond}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, image := range seen {
+		if image == testingdock.NetEmImage {
+			return
+		}
+	}
+	t.Errorf("rewrite rules not applied to the netem image, got: %v", seen)
+}
+
+func TestContainer_NetEm_restart(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestContainer_NetEm_restart", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestContainer_NetEm_restart",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		StopSignal: "SIGKILL",
+	})
+	s.Network(testingdock.NetworkOpts{Name: "TestContainer_NetEm_restart"}).After(c)
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	if err := c.NetEm(context.TODO(), testingdock.NetEmRules{Delay: time.Millisecond}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the rules are gone with the network namespace
+	if err := c.Restart(context.TODO(), time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.ClearNetEm(context.TODO()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := c.NetEm(context.TODO(), testingdock.NetEmRules{Delay: time.Millisecond}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.Stop(context.TODO(), time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// fails the test, if the rules of the stopped container can not be cleared
+	s.Reset(context.TODO())
+}
@@ -2,19 +2,24 @@ package testingdock
 
 import (
 	"context"
-	"sync"
 	"testing"
 
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 )
 
 // NetworkOpts is used when creating a new network.
 type NetworkOpts struct {
 	Name string
+	// Subnet of the network in CIDR format, e.g. 172.28.0.0/16. Only with
+	// a subnet containers keep their IP address on Reconnect.
+	Subnet string
+	// Hooks called during the lifecycle of the network.
+	Hooks NetworkHooks
 }
 
 // Network is a struct representing a docker network configuration.
@@ -25,10 +30,16 @@ type Network struct {
 	cli      *client.Client // docker API object to talk to the docker daemon
 	id, name string
 	gateway  string
-	cancel   func()
+	cancel   func(ctx context.Context)
 	children []*Container
 	closed   bool
 	labels   map[string]string
+	hooks    NetworkHooks
+	subnet   string
+
+	partitions []*partition
+	timeline   timeline
+	suite      *Suite
 }
 
 // Creates a new docker network configuration with the given options.
@@ -38,59 +49,103 @@ func newNetwork(t testing.TB, c *client.Client, opts NetworkOpts) *Network {
 		cli:    c,
 		name:   opts.Name,
 		labels: createTestingLabel(),
+		hooks:  opts.Hooks,
+		subnet: opts.Subnet,
 	}
 }
 
 // Creates the actual docker network and also starts the containers that
 // are part of the network.
 func (n *Network) start(ctx context.Context) {
-	n.initialCleanup(ctx)
+	if n.reusable() && n.adopt(ctx) {
+		printf("(setup ) %-25s (%s) - network reused", n.name, n.id)
+	} else {
+		done := n.phase(PhaseCleanup)
+		n.initialCleanup(ctx)
+		done()
 
-	res, err := n.cli.NetworkCreate(ctx, n.name, types.NetworkCreate{
-		Labels: n.labels,
-	})
-	if err != nil {
-		n.t.Fatalf("network creation failure: %s", err.Error())
+		n.mustHook(ctx, "BeforeCreate", n.hooks.BeforeCreate)
+		create := types.NetworkCreate{
+			Labels: n.labels,
+		}
+		if n.subnet != "" {
+			create.IPAM = &network.IPAM{Config: []network.IPAMConfig{{Subnet: n.subnet}}}
+		}
+		done = n.phase(PhaseCreate)
+		res, err := n.cli.NetworkCreate(ctx, n.name, create)
+		if err != nil {
+			n.t.Fatalf("network creation failure: %s", err.Error())
+		}
+		n.id = res.ID
+		done()
+		printf("(setup ) %-25s (%s) - network created", n.name, n.id)
+		n.mustHook(ctx, "AfterCreate", n.hooks.AfterCreate)
 	}
-	n.id = res.ID
-	n.cancel = func() {
+	n.cancel = func(ctx context.Context) {
 		if n.closed {
 			return
 		}
+		if n.reusable() {
+			printf("(cancel) %-25s (%s) - network kept for reuse", n.name, n.id)
+			return
+		}
+		done := n.phase(PhaseRemove)
 		if err := n.cli.NetworkRemove(ctx, n.id); err != nil {
 			n.t.Fatalf("network removal failure: %s", err.Error())
 		}
+		done()
 		printf("(cancel) %-25s (%s) - network removed", n.name, n.id)
 	}
-	printf("(setup ) %-25s (%s) - network created", n.name, n.id)
 
 	ni, err := n.cli.NetworkInspect(ctx, n.id, false)
 	if err != nil {
-		n.cancel()
+		n.cancel(ctx)
 		n.t.Fatalf("network inspect failure: %s", err.Error())
 	}
 	n.gateway = ni.IPAM.Config[0].Gateway
 	printf("(setup ) %-25s (%s) - network got gateway ip: %s", n.name, n.id, n.gateway)
+	n.mustHook(ctx, "AfterStart", n.hooks.AfterStart)
 
 	// start child containers
-	if SpawnSequential {
-		for _, cont := range n.children {
-			cont.start(ctx)
-		}
-	} else {
+	if !SpawnSequential {
 		printf("(setup ) %-25s (%s) - network is spawning %d child containers in parallel", n.name, n.id, len(n.children))
+	}
+	forEach(n.children, func(c *Container) error { // nolint: errcheck
+		c.start(ctx)
+		return nil
+	})
+
+	n.mustHook(ctx, "AfterHealthy", n.hooks.AfterHealthy)
+}
 
-		var wg sync.WaitGroup
+// adopt takes over an existing network with the same name left by a
+// previous run. Returns false if there is no such network.
+func (n *Network) adopt(ctx context.Context) bool {
+	networkListArgs := filters.NewArgs()
+	networkListArgs.Add("name", n.name)
+
+	networks, err := n.cli.NetworkList(ctx, types.NetworkListOptions{Filters: networkListArgs})
+	if err != nil {
+		n.t.Fatalf("network listing failure: %s", err.Error())
+	}
+	for _, nn := range networks {
+		if nn.Name == n.name && isOwnedByTestingdock(nn.Labels) {
+			n.id = nn.ID
+			return true
+		}
+	}
+	return false
+}
 
-		wg.Add(len(n.children))
-		for _, cont := range n.children {
-			go func(cont *Container) {
-				defer wg.Done()
-				cont.start(ctx)
-			}(cont)
+// reusable returns true if any container of the network is reused across
+// test runs, in which case the network is kept as well.
+func (n *Network) reusable() bool {
+	for _, c := range n.children {
+		if c.reusable() {
+			return true
 		}
-		wg.Wait()
 	}
+	return false
 }
 
 // removes the network if it already exists and all containers being part
@@ -139,32 +194,36 @@ func (n *Network) initialCleanup(ctx context.Context) {
 
 // Closes the docker network. This also closes the
 // children containers if any are set in the Network struct.
-// Implements io.Closer interface.
-func (n *Network) close() error {
-	if SpawnSequential {
-		for _, cont := range n.children {
-			cont.close() // nolint: errcheck
-		}
-	} else {
-		var wg sync.WaitGroup
-
-		wg.Add(len(n.children))
-		for _, cont := range n.children {
-			go func(cont *Container) {
-				defer wg.Done()
-				cont.close() // nolint: errcheck
-			}(cont)
+// All containers are stopped before anything gets removed.
+func (n *Network) close(ctx context.Context) error {
+	herr := n.hook(ctx, "BeforeStop", n.hooks.BeforeStop)
+
+	err := forEach(n.children, func(c *Container) error {
+		return c.stop(ctx)
+	})
+	forEach(n.children, func(c *Container) error { // nolint: errcheck
+		return c.close(ctx)
+	})
+	// the containers are removed, so the partitions have no endpoints left
+	for len(n.partitions) > 0 {
+		if perr := n.removePartition(ctx, n.partitions[len(n.partitions)-1]); perr != nil {
+			n.t.Fatalf("partition network removal failure: %s", perr.Error())
 		}
-		wg.Wait()
 	}
 
 	// if the network failed to start n.cancel will not be set
-	if n.cancel != nil {
-		n.cancel()
+	if n.cancel != nil && !n.closed {
+		n.cancel(ctx)
+		if aerr := n.hook(ctx, "AfterClose", n.hooks.AfterClose); aerr != nil && herr == nil {
+			herr = aerr
+		}
 	}
 
 	n.closed = true
-	return nil
+	if err == nil {
+		err = herr
+	}
+	return err
 }
 
 // After adds a child container to the current network configuration.
@@ -175,11 +234,24 @@ func (n *Network) After(c *Container) {
 	n.children = append(n.children, c)
 }
 
+// containers returns all containers of the network, including the
+// children of containers, parents first.
+func (n *Network) containers() []*Container {
+	var all []*Container
+	for _, c := range n.children {
+		all = append(all, c.tree()...)
+	}
+	return all
+}
+
 // resets the network and the child containers.
 func (n *Network) reset(ctx context.Context) {
 	now := time.Now()
-	for _, c := range n.children {
+	n.mustHook(ctx, "BeforeReset", n.hooks.BeforeReset)
+	forEach(n.children, func(c *Container) error { // nolint: errcheck
 		c.reset(ctx)
-	}
+		return nil
+	})
+	n.mustHook(ctx, "AfterReset", n.hooks.AfterReset)
 	printf("(reset ) %-25s (%s) - network reseted in %s", n.name, n.id, time.Since(now))
 }
@@ -5,6 +5,7 @@ import (
 
 	"context"
 
+	"github.com/docker/docker/api/types/container"
 	"github.com/piotrkowalczuk/testingdock"
 )
 
@@ -22,3 +23,65 @@ func TestNetwork_Start(t *testing.T) {
 		t.Fatalf("Failed to close a network: %s", err.Error())
 	}
 }
+
+func TestNetwork_Partition(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestNetwork_Partition", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	n := s.Network(testingdock.NetworkOpts{
+		Name:   "TestNetwork_Partition",
+		Subnet: "172.28.0.0/16",
+	})
+	var cs []*testingdock.Container
+	for _, name := range []string{"a", "b", "c"} {
+		c := s.Container(testingdock.ContainerOpts{
+			Name: "TestNetwork_Partition_" + name,
+			Config: &container.Config{
+				Image: "alpine:3.6",
+				Cmd:   []string{"sleep", "60"},
+			},
+			StopSignal: "SIGKILL",
+		})
+		n.After(c)
+		cs = append(cs, c)
+	}
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	networks := func(c *testingdock.Container) map[string]string {
+		cjson, err := c.Inspect(context.TODO())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		res := make(map[string]string)
+		for name, es := range cjson.NetworkSettings.Networks {
+			res[name] = es.IPAddress
+		}
+		return res
+	}
+	ip := networks(cs[1])["TestNetwork_Partition"]
+
+	if err := n.Partition(context.TODO(), cs[:1], cs[1:2]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := networks(cs[1])["TestNetwork_Partition"]; ok {
+		t.Error("b should be isolated from a")
+	}
+	if len(networks(cs[2])) != 2 {
+		t.Errorf("c should reach both groups, got: %v", networks(cs[2]))
+	}
+
+	if err := n.Heal(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, c := range cs {
+		if got := networks(c); len(got) != 1 {
+			t.Errorf("container %s should be only in the network, got: %v", c.Name, got)
+		}
+	}
+	if got := networks(cs[1])["TestNetwork_Partition"]; got != ip {
+		t.Errorf("b should keep its ip %s, got: %s", ip, got)
+	}
+}
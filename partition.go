@@ -0,0 +1,198 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+// partition is a temporary network, which one group of containers of a
+// partitioned network is moved to.
+type partition struct {
+	id, name string
+	// isolated containers were moved from the network to the partition
+	isolated []*Container
+	// bystanders are connected to both, so they reach both groups
+	bystanders []*Container
+}
+
+// Partition splits the network, so the containers in groupA and groupB can
+// no longer reach each other, until Heal is called. The containers in groupB
+// are moved to a temporary network, while all other containers of the
+// network are connected to both, so they still reach both groups.
+func (n *Network) Partition(ctx context.Context, groupA, groupB []*Container) error {
+	members := make(map[*Container]bool)
+	for _, c := range n.containers() {
+		members[c] = true
+	}
+	grouped := make(map[*Container]bool)
+	for _, c := range append(append([]*Container{}, groupA...), groupB...) {
+		if !members[c] {
+			return fmt.Errorf("container %s is not part of network %s", c.Name, n.name)
+		}
+		if grouped[c] {
+			return fmt.Errorf("container %s is in both groups", c.Name)
+		}
+		grouped[c] = true
+	}
+
+	labels := copyLabels(n.labels)
+	p := &partition{name: fmt.Sprintf("%s_partition_%d", n.name, len(n.partitions))}
+	res, err := n.cli.NetworkCreate(ctx, p.name, types.NetworkCreate{Labels: labels})
+	if err != nil {
+		return err
+	}
+	p.id = res.ID
+	n.partitions = append(n.partitions, p)
+
+	for _, c := range groupB {
+		settings, err := c.endpoint(ctx, n)
+		if err != nil {
+			return err
+		}
+		if err = c.Disconnect(ctx, n); err != nil {
+			return err
+		}
+		if err = n.cli.NetworkConnect(ctx, p.id, c.ID, &network.EndpointSettings{Aliases: settings.Aliases}); err != nil {
+			return err
+		}
+		p.isolated = append(p.isolated, c)
+	}
+	for c := range members {
+		if grouped[c] || c.ID == "" || c.isDisconnected(n) {
+			continue
+		}
+		settings, err := c.endpoint(ctx, n)
+		if err != nil {
+			return err
+		}
+		if err = n.cli.NetworkConnect(ctx, p.id, c.ID, &network.EndpointSettings{Aliases: settings.Aliases}); err != nil {
+			return err
+		}
+		p.bystanders = append(p.bystanders, c)
+	}
+
+	printf("(fault ) %-25s (%s) - network partitioned, %d containers isolated in: %s", n.name, n.id, len(p.isolated), p.name)
+	return nil
+}
+
+// Heal reverts all partitions of the network, see Partition. The isolated
+// containers are reconnected with the same aliases and, if the network has a
+// subnet, the same IP addresses.
+func (n *Network) Heal(ctx context.Context) error {
+	for len(n.partitions) > 0 {
+		p := n.partitions[len(n.partitions)-1]
+		for _, c := range p.isolated {
+			if err := n.cli.NetworkDisconnect(ctx, p.id, c.ID, true); err != nil {
+				return err
+			}
+			if err := c.Reconnect(ctx, n); err != nil {
+				return err
+			}
+		}
+		for _, c := range p.bystanders {
+			if err := n.cli.NetworkDisconnect(ctx, p.id, c.ID, true); err != nil {
+				return err
+			}
+		}
+		if err := n.removePartition(ctx, p); err != nil {
+			return err
+		}
+	}
+	printf("(fault ) %-25s (%s) - network healed", n.name, n.id)
+	return nil
+}
+
+// removePartition removes the partition network, which must not have any
+// endpoints left.
+func (n *Network) removePartition(ctx context.Context, p *partition) error {
+	if err := n.cli.NetworkRemove(ctx, p.id); err != nil {
+		return err
+	}
+	n.partitions = n.partitions[:len(n.partitions)-1]
+	printf("(cancel) %-25s (%s) - partition network removed", p.name, p.id)
+	return nil
+}
+
+// Disconnect disconnects the container from the network, e.g. to simulate an
+// outage of a dependency, until Reconnect is called.
+func (c *Container) Disconnect(ctx context.Context, n *Network) error {
+	settings, err := c.endpoint(ctx, n)
+	if err != nil {
+		return err
+	}
+	if err = c.cli.NetworkDisconnect(ctx, n.id, c.ID, false); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.disconnected == nil {
+		c.disconnected = make(map[string]*network.EndpointSettings)
+	}
+	c.disconnected[n.id] = settings
+	c.mu.Unlock()
+
+	printf("(fault ) %-25s (%s) - container disconnected from: %s", c.Name, c.ID, n.name)
+	return nil
+}
+
+// Reconnect connects the container disconnected by Disconnect to the network
+// again, with the same aliases and, if the network has a subnet, the same IP
+// address.
+func (c *Container) Reconnect(ctx context.Context, n *Network) error {
+	c.mu.Lock()
+	settings, ok := c.disconnected[n.id]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("container %s was not disconnected from network %s", c.Name, n.name)
+	}
+
+	if err := c.cli.NetworkConnect(ctx, n.id, c.ID, settings); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	delete(c.disconnected, n.id)
+	c.mu.Unlock()
+
+	printf("(fault ) %-25s (%s) - container reconnected to: %s", c.Name, c.ID, n.name)
+	return nil
+}
+
+// endpoint returns the settings to connect the container to the network
+// again, like it is connected now.
+func (c *Container) endpoint(ctx context.Context, n *Network) (*network.EndpointSettings, error) {
+	cjson, err := c.Inspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var es *network.EndpointSettings
+	if cjson.NetworkSettings != nil {
+		es = cjson.NetworkSettings.Networks[n.name]
+	}
+	if es == nil {
+		return nil, fmt.Errorf("container %s is not connected to network %s", c.Name, n.name)
+	}
+
+	settings := &network.EndpointSettings{
+		IPAMConfig: es.IPAMConfig,
+		Links:      es.Links,
+		Aliases:    withoutAlias(es.Aliases, c.ID),
+	}
+	// docker supports static addresses only in networks with a configured subnet
+	if n.subnet != "" && es.IPAddress != "" {
+		settings.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: es.IPAddress}
+	}
+	return settings, nil
+}
+
+// isDisconnected returns true if the container was disconnected from the
+// network by Disconnect.
+func (c *Container) isDisconnected(n *Network) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.disconnected[n.id]
+	return ok
+}
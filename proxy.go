@@ -0,0 +1,352 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// Toxics configure the faults a Proxy injects. The zero value forwards the
+// data unchanged. Toxics can be changed at any time and apply to new and
+// established connections, from the next chunk of data on.
+type Toxics struct {
+	// Latency is added to every chunk of data, in both directions, Jitter
+	// randomly adds or subtracts up to the given duration. A chunk is up to
+	// 32 KiB read at once and the chunks are delayed one after another, so
+	// a large payload is delayed by the latency once per chunk
+	Latency time.Duration
+	Jitter  time.Duration
+	// Bandwidth limits each direction of a connection to the given bytes
+	// per second, 0 means no limit
+	Bandwidth int
+	// SlowClose delays closing the connection after the other side closed it
+	SlowClose time.Duration
+	// ResetPeer resets all connections with a TCP RST
+	ResetPeer bool
+	// Blackhole drops all data, the connections are kept open
+	Blackhole bool
+	// Timeout drops all data and closes the connections after the duration,
+	// counted from the first data dropped. Changing the toxics stops it.
+	Timeout time.Duration
+	// Truncate closes a connection after the given number of bytes was sent
+	// back from the upstream, 0 means no limit
+	Truncate int64
+}
+
+// Proxy is a TCP proxy, which injects faults configured by Toxics between
+// its clients and the upstream address.
+type Proxy struct {
+	listener net.Listener
+	upstream string
+	gateway  string
+
+	mu     sync.Mutex
+	toxics Toxics
+	conns  map[*proxyConn]struct{}
+	closed bool
+}
+
+// proxyConn is a proxied connection between a client and the upstream.
+type proxyConn struct {
+	client, upstream net.Conn
+	once             sync.Once
+	// closes the connection for the Timeout toxic, guarded by the proxy
+	timer *time.Timer
+}
+
+// NewProxy creates a proxy to the upstream address, listening on a random
+// port of all interfaces.
+func NewProxy(upstream string) (*Proxy, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		listener: l,
+		upstream: upstream,
+		conns:    make(map[*proxyConn]struct{}),
+	}
+	go p.serve()
+	return p, nil
+}
+
+// Proxy creates a proxy to the host port the given container port is
+// published on, e.g. "5432/tcp". The proxy is closed with the suite and its
+// toxics are cleared on Suite.Reset.
+func (c *Container) Proxy(ctx context.Context, port string) (*Proxy, error) {
+	addr, err := c.HostAddr(ctx, port)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewProxy(addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.network != nil {
+		p.gateway = c.network.gateway
+	}
+	if c.suite != nil {
+		c.suite.mu.Lock()
+		c.suite.proxies = append(c.suite.proxies, p)
+		c.suite.mu.Unlock()
+	}
+	printf("(proxy ) %-25s (%s) - proxy for %s listening on: %s", c.Name, c.ID, port, p.Addr())
+	return p, nil
+}
+
+// HostAddr returns the host address the given container port is published
+// on, e.g. "5432/tcp" or just "5432".
+func (c *Container) HostAddr(ctx context.Context, port string) (string, error) {
+	if !strings.Contains(port, "/") {
+		port += "/tcp"
+	}
+	cjson, err := c.Inspect(ctx)
+	if err != nil {
+		return "", err
+	}
+	if cjson.NetworkSettings != nil {
+		for _, b := range cjson.NetworkSettings.Ports[nat.Port(port)] {
+			host := b.HostIP
+			if host == "" || host == "0.0.0.0" {
+				host = "127.0.0.1"
+			}
+			return net.JoinHostPort(host, b.HostPort), nil
+		}
+	}
+	return "", fmt.Errorf("port %s of container %s is not published", port, c.Name)
+}
+
+// Addr returns the address of the proxy reachable from the test process.
+func (p *Proxy) Addr() string {
+	return net.JoinHostPort("127.0.0.1", p.port())
+}
+
+// NetworkAddr returns the address of the proxy reachable from containers in
+// the suite network, via its gateway, so the proxy can be put between two
+// containers. It is only set for proxies created by Container.Proxy.
+func (p *Proxy) NetworkAddr() string {
+	if p.gateway == "" {
+		return ""
+	}
+	return net.JoinHostPort(p.gateway, p.port())
+}
+
+func (p *Proxy) port() string {
+	_, port, _ := net.SplitHostPort(p.listener.Addr().String())
+	return port
+}
+
+// SetToxics replaces the toxics of the proxy.
+func (p *Proxy) SetToxics(toxics Toxics) {
+	p.mu.Lock()
+	p.toxics = toxics
+	var conns []*proxyConn
+	for conn := range p.conns {
+		// a Timeout toxic restarts with the next data dropped
+		stopTimer(conn)
+		if toxics.ResetPeer {
+			conns = append(conns, conn)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, conn := range conns {
+		p.reset(conn)
+	}
+}
+
+// Toxics returns the current toxics of the proxy.
+func (p *Proxy) Toxics() Toxics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.toxics
+}
+
+// Clear removes all toxics, so the data is forwarded unchanged.
+func (p *Proxy) Clear() {
+	p.SetToxics(Toxics{})
+}
+
+// Close stops the proxy and closes all connections.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	var conns []*proxyConn
+	for conn := range p.conns {
+		conns = append(conns, conn)
+	}
+	p.mu.Unlock()
+
+	err := p.listener.Close()
+	for _, conn := range conns {
+		p.close(conn)
+	}
+	return err
+}
+
+func (p *Proxy) serve() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return // listener closed
+		}
+		go p.handle(client)
+	}
+}
+
+func (p *Proxy) handle(client net.Conn) {
+	if p.Toxics().ResetPeer {
+		resetConn(client)
+		return
+	}
+	upstream, err := net.Dial("tcp", p.upstream)
+	if err != nil {
+		printf("(proxy ) %-25s - upstream connection failure: %s", p.upstream, err)
+		resetConn(client)
+		return
+	}
+
+	conn := &proxyConn{client: client, upstream: upstream}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.close(conn)
+		return
+	}
+	p.conns[conn] = struct{}{}
+	p.mu.Unlock()
+
+	go p.pipe(conn, upstream, client, false)
+	p.pipe(conn, client, upstream, true)
+}
+
+// pipe copies the data from src to dst, applying the toxics to every chunk.
+func (p *Proxy) pipe(conn *proxyConn, dst, src net.Conn, downstream bool) {
+	var written int64
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			toxics := p.Toxics()
+			chunk := buf[:n]
+
+			switch {
+			case toxics.ResetPeer:
+				p.reset(conn)
+				return
+			case toxics.Timeout > 0:
+				p.mu.Lock()
+				if conn.timer == nil {
+					conn.timer = time.AfterFunc(toxics.Timeout, func() { p.close(conn) })
+				}
+				p.mu.Unlock()
+				continue
+			case toxics.Blackhole:
+				continue
+			}
+
+			if downstream && toxics.Truncate > 0 && written+int64(len(chunk)) > toxics.Truncate {
+				if rest := toxics.Truncate - written; rest > 0 {
+					writeToxic(dst, chunk[:rest], toxics) // nolint: errcheck
+				}
+				p.close(conn)
+				return
+			}
+			if werr := writeToxic(dst, chunk, toxics); werr != nil {
+				p.close(conn)
+				return
+			}
+			written += int64(len(chunk))
+		}
+		if err != nil {
+			if err == io.EOF {
+				if d := p.Toxics().SlowClose; d > 0 {
+					time.Sleep(d)
+				}
+			}
+			p.close(conn)
+			return
+		}
+	}
+}
+
+// writeToxic writes the chunk delayed by the latency and limited by the
+// bandwidth of the toxics.
+func writeToxic(dst io.Writer, chunk []byte, toxics Toxics) error {
+	delay := toxics.Latency
+	if toxics.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(2*toxics.Jitter))) - toxics.Jitter
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if toxics.Bandwidth <= 0 {
+		_, err := dst.Write(chunk)
+		return err
+	}
+
+	// write in slices of a tenth of the bandwidth per 100ms
+	size := toxics.Bandwidth / 10
+	if size == 0 {
+		size = 1
+	}
+	for len(chunk) > 0 {
+		n := size
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+		start := time.Now()
+		if _, err := dst.Write(chunk[:n]); err != nil {
+			return err
+		}
+		chunk = chunk[n:]
+		time.Sleep(time.Duration(n)*time.Second/time.Duration(toxics.Bandwidth) - time.Since(start))
+	}
+	return nil
+}
+
+// close closes both sides of the connection.
+func (p *Proxy) close(conn *proxyConn) {
+	conn.once.Do(func() {
+		conn.client.Close()   // nolint: errcheck
+		conn.upstream.Close() // nolint: errcheck
+		p.mu.Lock()
+		stopTimer(conn)
+		delete(p.conns, conn)
+		p.mu.Unlock()
+	})
+}
+
+// stopTimer stops the timer of the Timeout toxic, the proxy has to be locked.
+func stopTimer(conn *proxyConn) {
+	if conn.timer != nil {
+		conn.timer.Stop()
+		conn.timer = nil
+	}
+}
+
+// reset closes both sides of the connection with a TCP RST.
+func (p *Proxy) reset(conn *proxyConn) {
+	resetConn(conn.client)
+	resetConn(conn.upstream)
+	p.close(conn)
+}
+
+// resetConn closes the connection with a TCP RST instead of a FIN.
+func resetConn(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0) // nolint: errcheck
+	}
+	conn.Close() // nolint: errcheck
+}
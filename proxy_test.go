@@ -0,0 +1,176 @@
+package testingdock_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/piotrkowalczuk/testingdock"
+)
+
+// echoServer starts a TCP server, which echoes everything back.
+func echoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func TestProxy(t *testing.T) {
+	l := echoServer(t)
+	defer l.Close()
+
+	p, err := testingdock.NewProxy(l.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer p.Close()
+
+	roundTrip := func(msg []byte) ([]byte, time.Duration, error) {
+		conn, err := net.Dial("tcp", p.Addr())
+		if err != nil {
+			return nil, 0, err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+		start := time.Now()
+		if _, err = conn.Write(msg); err != nil {
+			return nil, 0, err
+		}
+		buf := make([]byte, len(msg))
+		n, err := io.ReadFull(conn, buf)
+		return buf[:n], time.Since(start), err
+	}
+	msg := []byte("hello testingdock")
+
+	t.Run("forward", func(t *testing.T) {
+		got, _, err := roundTrip(msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Errorf("wrong response: %s", got)
+		}
+	})
+	t.Run("latency", func(t *testing.T) {
+		p.SetToxics(testingdock.Toxics{Latency: 100 * time.Millisecond})
+		defer p.Clear()
+
+		_, took, err := roundTrip(msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// applied in both directions
+		if took < 200*time.Millisecond {
+			t.Errorf("expected latency of at least 200ms, got: %s", took)
+		}
+	})
+	t.Run("bandwidth", func(t *testing.T) {
+		p.SetToxics(testingdock.Toxics{Bandwidth: 100})
+		defer p.Clear()
+
+		_, took, err := roundTrip(bytes.Repeat([]byte("x"), 50))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// both directions are limited, but pipelined
+		if took < 400*time.Millisecond {
+			t.Errorf("expected 50 bytes at 100 bytes/s to take at least 400ms, got: %s", took)
+		}
+	})
+	t.Run("truncate", func(t *testing.T) {
+		p.SetToxics(testingdock.Toxics{Truncate: 5})
+		defer p.Clear()
+
+		got, _, err := roundTrip(msg)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if string(got) != "hello" {
+			t.Errorf("wrong response: %s", got)
+		}
+	})
+	t.Run("reset peer", func(t *testing.T) {
+		p.SetToxics(testingdock.Toxics{ResetPeer: true})
+		defer p.Clear()
+
+		if _, _, err := roundTrip(msg); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		p.SetToxics(testingdock.Toxics{Timeout: 100 * time.Millisecond})
+		defer p.Clear()
+
+		got, took, err := roundTrip(msg)
+		if err != io.EOF || len(got) != 0 {
+			t.Fatalf("expected the connection to be closed, got: %s %v", got, err)
+		}
+		if took > time.Second {
+			t.Errorf("expected the connection to be closed after the timeout, got: %s", took)
+		}
+	})
+	t.Run("timeout cleared", func(t *testing.T) {
+		p.SetToxics(testingdock.Toxics{Timeout: 200 * time.Millisecond})
+
+		conn, err := net.Dial("tcp", p.Addr())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer conn.Close()
+		// starts the timeout
+		if _, err = conn.Write(msg); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		p.Clear()
+
+		// the connection outlives the timeout of the cleared toxic
+		time.Sleep(300 * time.Millisecond)
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err = conn.Write(msg); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		buf := make([]byte, len(msg))
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			t.Errorf("connection closed by the cleared timeout: %s", err)
+		}
+	})
+	t.Run("blackhole", func(t *testing.T) {
+		p.SetToxics(testingdock.Toxics{Blackhole: true})
+		defer p.Clear()
+
+		conn, err := net.Dial("tcp", p.Addr())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if _, err = conn.Write(msg); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err = ioutil.ReadAll(conn); err == nil {
+			t.Fatal("expected timeout")
+		}
+		if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+			t.Errorf("expected timeout, got: %s", err)
+		}
+	})
+}
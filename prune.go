@@ -0,0 +1,123 @@
+package testingdock
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// PruneOpts is an option struct for Prune.
+type PruneOpts struct {
+	// Suite limits the removal to the resources of the suite with the
+	// given name
+	Suite string
+	// Images removes the images built or snapshotted by testingdock as well
+	Images bool
+}
+
+// ListContainers returns all containers labeled owner=testingdock, limited
+// to the suite with the given name, if set.
+func ListContainers(ctx context.Context, cli *client.Client, suite string) ([]types.Container, error) {
+	return cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: ownerFilters(suite),
+	})
+}
+
+// Prune removes all containers, networks and volumes labeled
+// owner=testingdock, e.g. left by crashed test runs or kept for debugging.
+func Prune(ctx context.Context, cli *client.Client, opts PruneOpts) error {
+	args := ownerFilters(opts.Suite)
+
+	containers, err := ListContainers(ctx, cli, opts.Suite)
+	if err != nil {
+		return err
+	}
+	for _, cont := range containers {
+		if err = cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		}); err != nil {
+			return err
+		}
+		printf("(prune ) %-25s (%s) - container removed", cont.Names[0], cont.ID)
+	}
+
+	networks, err := cli.NetworkList(ctx, types.NetworkListOptions{Filters: args})
+	if err != nil {
+		return err
+	}
+	for _, n := range networks {
+		if err = cli.NetworkRemove(ctx, n.ID); err != nil {
+			return err
+		}
+		printf("(prune ) %-25s (%s) - network removed", n.Name, n.ID)
+	}
+
+	volumes, err := cli.VolumeList(ctx, args)
+	if err != nil {
+		return err
+	}
+	for _, v := range volumes.Volumes {
+		if err = cli.VolumeRemove(ctx, v.Name, true); err != nil {
+			return err
+		}
+		printf("(prune ) %-25s (%-64s) - volume removed", v.Name, "")
+	}
+
+	if !opts.Images {
+		return nil
+	}
+	images, err := cli.ImageList(ctx, types.ImageListOptions{Filters: args})
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		if _, err = cli.ImageRemove(ctx, img.ID, types.ImageRemoveOptions{
+			Force:         true,
+			PruneChildren: true,
+		}); err != nil {
+			return err
+		}
+		printf("(prune ) %-25s (%s) - image removed", img.RepoTags, img.ID)
+	}
+	return nil
+}
+
+// ownerFilters returns the label filters of the testingdock resources,
+// limited to the suite, if set.
+func ownerFilters(suite string) filters.Args {
+	args := filters.NewArgs()
+	args.Add("label", "owner=testingdock")
+	if suite != "" {
+		args.Add("label", SuiteLabel+"="+suite)
+	}
+	return args
+}
+
+// Down removes the network, containers and volumes of the suite, including
+// the ones kept for reuse or left by a previous run, and all other
+// resources labeled with the suite name. The suite does not have to be
+// started, so it can tear down an environment started by another process.
+func (s *Suite) Down(ctx context.Context) {
+	if s.network != nil {
+		for _, c := range s.network.containers() {
+			c.initialCleanup(ctx)
+			if err := removeHelper(ctx, c.cli, c.Name+"_netem"); err != nil {
+				s.t.Fatalf("netem helper removal failure: %s", err.Error())
+			}
+			for _, v := range c.volumes {
+				if err := removeVolume(ctx, c.cli, v.Name); err != nil {
+					s.t.Fatalf("volume removal failure: %s", err.Error())
+				}
+			}
+		}
+		s.network.initialCleanup(ctx)
+	}
+	if err := Prune(ctx, s.cli, PruneOpts{Suite: s.name}); err != nil {
+		s.t.Fatalf("suite %s pruning failure: %s", s.name, err.Error())
+	}
+	printf("(down  ) %-25s - suite removed", s.name)
+}
@@ -0,0 +1,165 @@
+package testingdock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// ResetRecreate is a pre-implemented ResetFunc, which removes the container
+// and creates it again from the original configuration, so no state is left
+// in its filesystem or anonymous volumes. The new container is attached to
+// the same networks with the same aliases and gets the same host ports.
+func ResetRecreate() ResetFunc {
+	return func(ctx context.Context, c *Container) error {
+		return c.recreate(ctx, c.image)
+	}
+}
+
+// ResetSnapshot is a pre-implemented ResetFunc, which recreates the container
+// from the image it was committed into after its first healthy start,
+// including the changes made by the AfterHealthy hook, like ResetRecreate.
+// The snapshot is only taken if ContainerOpts.Snapshot is set.
+//
+// Note that data in volumes is not part of the snapshot, e.g. the data
+// directory of most database images is a volume.
+func ResetSnapshot() ResetFunc {
+	return func(ctx context.Context, c *Container) error {
+		if c.snapshot == "" {
+			return fmt.Errorf("container %s has no snapshot, ContainerOpts.Snapshot is not set", c.Name)
+		}
+		return c.recreate(ctx, c.snapshot)
+	}
+}
+
+// takeSnapshot commits the container into an image used by ResetSnapshot.
+func (c *Container) takeSnapshot(ctx context.Context) error {
+	ref := "localhost/testingdock/" + strings.ToLower(c.Name) + "-snapshot:latest"
+	if _, err := c.cli.ContainerCommit(ctx, c.ID, types.ContainerCommitOptions{
+		Reference: ref,
+		Comment:   "testingdock snapshot of " + c.Name,
+		Pause:     true,
+	}); err != nil {
+		return err
+	}
+	c.snapshot = ref
+	printf("(setup ) %-25s (%s) - container snapshot taken: %s", c.Name, c.ID, ref)
+	return nil
+}
+
+// removeSnapshot removes the snapshot image, if any.
+func (c *Container) removeSnapshot(ctx context.Context) error {
+	if c.snapshot == "" {
+		return nil
+	}
+	if _, err := c.cli.ImageRemove(ctx, c.snapshot, types.ImageRemoveOptions{
+		Force:         true,
+		PruneChildren: true,
+	}); err != nil {
+		return err
+	}
+	printf("(cancel) %-25s (%s) - container snapshot removed: %s", c.Name, c.ID, c.snapshot)
+	c.snapshot = ""
+	return nil
+}
+
+// recreate replaces the container with a new one created from the given
+// image and the configuration it was created with originally. It is started
+// or, for jobs, run to completion.
+func (c *Container) recreate(ctx context.Context, image string) error {
+	cjson, err := c.Inspect(ctx)
+	if err != nil {
+		return err
+	}
+
+	ccfg := *c.createCfg
+	ccfg.Image = image
+	hcfg := *c.createHostCfg
+	hcfg.PortBindings = boundPorts(hcfg.PortBindings, cjson)
+
+	// docker allows only one network on create, the others are connected afterwards
+	endpoints := make(map[string]*network.EndpointSettings)
+	if cjson.NetworkSettings != nil {
+		for name, es := range cjson.NetworkSettings.Networks {
+			endpoints[name] = &network.EndpointSettings{
+				IPAMConfig: es.IPAMConfig,
+				Links:      es.Links,
+				Aliases:    withoutAlias(es.Aliases, c.ID),
+			}
+		}
+	}
+	primary := c.network.name
+	ncfg := &network.NetworkingConfig{EndpointsConfig: make(map[string]*network.EndpointSettings)}
+	if es, ok := endpoints[primary]; ok {
+		ncfg.EndpointsConfig[primary] = es
+		delete(endpoints, primary)
+	}
+
+	if err = c.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+		return err
+	}
+	cont, err := c.cli.ContainerCreate(ctx, &ccfg, &hcfg, ncfg, c.Name)
+	if err != nil {
+		return err
+	}
+	printf("(reset ) %-25s (%s) - container recreated from: %s", c.Name, cont.ID, image)
+	c.ID = cont.ID
+	c.mu.Lock()
+	c.netem = nil
+	c.mu.Unlock()
+
+	for name, es := range endpoints {
+		if err = c.cli.NetworkConnect(ctx, name, c.ID, es); err != nil {
+			return err
+		}
+	}
+	if c.coverage != nil {
+		if err = c.prepareCoverage(ctx); err != nil {
+			return err
+		}
+	}
+	if err = c.hook(ctx, "AfterCreate", c.hooks.AfterCreate); err != nil {
+		return err
+	}
+
+	if c.job {
+		err = c.runJob(ctx)
+	} else {
+		err = c.cli.ContainerStart(ctx, c.ID, types.ContainerStartOptions{})
+	}
+	c.startSampling()
+	return err
+}
+
+// boundPorts returns the port bindings with the host ports the container
+// actually got, so randomly assigned ports stay the same.
+func boundPorts(bindings nat.PortMap, cjson *types.ContainerJSON) nat.PortMap {
+	res := make(nat.PortMap, len(bindings))
+	for port, b := range bindings {
+		res[port] = b
+	}
+	if cjson.NetworkSettings == nil {
+		return res
+	}
+	for port, b := range cjson.NetworkSettings.Ports {
+		if _, ok := bindings[port]; ok && len(b) > 0 {
+			res[port] = b
+		}
+	}
+	return res
+}
+
+// withoutAlias removes the alias docker adds for the short container ID.
+func withoutAlias(aliases []string, id string) []string {
+	var res []string
+	for _, alias := range aliases {
+		if !strings.HasPrefix(id, alias) {
+			res = append(res, alias)
+		}
+	}
+	return res
+}
@@ -0,0 +1,147 @@
+package testingdock
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RewriteEnv is the name of the environment variable holding image rewrite
+// rules, see ParseRewriteRules for the format.
+const RewriteEnv = "TESTINGDOCK_IMAGE_REWRITE"
+
+const (
+	defaultRegistry       = "docker.io"
+	legacyDefaultRegistry = "index.docker.io"
+)
+
+// RewriteFunc is the type of an image reference rewriting function. It
+// returns the rewritten reference and true if the rule matched, otherwise
+// the second return value is false.
+//
+// Rewriting happens before images are listed, pulled and used to create
+// containers. The original reference is still used in logs and labels.
+type RewriteFunc func(image string) (string, bool)
+
+// RewritePrefix is a pre-implemented RewriteFunc, which substitutes the
+// given prefix of an image reference, e.g.:
+//  RewritePrefix("quay.io/", "mirror.example.com/quay/")
+func RewritePrefix(prefix, replacement string) RewriteFunc {
+	return func(image string) (string, bool) {
+		if !strings.HasPrefix(image, prefix) {
+			return image, false
+		}
+		return replacement + strings.TrimPrefix(image, prefix), true
+	}
+}
+
+// RewriteMirror is a pre-implemented RewriteFunc, which redirects all
+// images of the given registry to a mirror. References without a registry
+// are normalized the same way as the docker CLI does, so
+//  RewriteMirror("docker.io", "mirror.example.com")
+// rewrites "postgres:9.6" to "mirror.example.com/library/postgres:9.6".
+func RewriteMirror(registry, mirror string) RewriteFunc {
+	registry = normalizeRegistry(registry)
+	mirror = strings.TrimSuffix(mirror, "/")
+
+	return func(image string) (string, bool) {
+		domain, remainder := splitImageDomain(image)
+		if domain != registry {
+			return image, false
+		}
+		return mirror + "/" + remainder, true
+	}
+}
+
+// RewriteRegexp is a pre-implemented RewriteFunc, which replaces matches of
+// the given regular expression with the replacement, as in
+// regexp.Regexp.ReplaceAllString. It panics if the expression cannot be parsed.
+func RewriteRegexp(expr, replacement string) RewriteFunc {
+	return rewriteRegexp(regexp.MustCompile(expr), replacement)
+}
+
+func rewriteRegexp(re *regexp.Regexp, replacement string) RewriteFunc {
+	return func(image string) (string, bool) {
+		if !re.MatchString(image) {
+			return image, false
+		}
+		return re.ReplaceAllString(image, replacement), true
+	}
+}
+
+// ParseRewriteRules parses image rewrite rules in the format used by the
+// TESTINGDOCK_IMAGE_REWRITE environment variable. Rules are separated by
+// semicolons and have the form <kind>:<from>=<to>, where kind is one of
+// prefix, mirror or regexp, e.g.:
+//  mirror:docker.io=mirror.example.com;prefix:quay.io/=mirror.example.com/quay/
+func ParseRewriteRules(rules string) ([]RewriteFunc, error) {
+	var fns []RewriteFunc
+	for _, rule := range strings.Split(rules, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		kindAndFrom := strings.SplitN(rule, "=", 2)
+		if len(kindAndFrom) != 2 {
+			return nil, fmt.Errorf("rewrite rule %q is missing the '=' separator", rule)
+		}
+		kind := strings.SplitN(kindAndFrom[0], ":", 2)
+		if len(kind) != 2 || kind[1] == "" {
+			return nil, fmt.Errorf("rewrite rule %q is missing the kind or source", rule)
+		}
+		from, to := kind[1], kindAndFrom[1]
+
+		switch kind[0] {
+		case "prefix":
+			fns = append(fns, RewritePrefix(from, to))
+		case "mirror":
+			fns = append(fns, RewriteMirror(from, to))
+		case "regexp":
+			re, err := regexp.Compile(from)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite rule %q has an invalid expression: %s", rule, err)
+			}
+			fns = append(fns, rewriteRegexp(re, to))
+		default:
+			return nil, fmt.Errorf("rewrite rule %q has an unknown kind %q", rule, kind[0])
+		}
+	}
+
+	return fns, nil
+}
+
+// rewriteImage applies the first matching rule to the image reference.
+func rewriteImage(rules []RewriteFunc, image string) string {
+	for _, rule := range rules {
+		if rewritten, ok := rule(image); ok {
+			return rewritten
+		}
+	}
+	return image
+}
+
+// splitImageDomain splits an image reference into the registry domain and
+// the remainder, following the rules of docker's reference normalization:
+// the first component is a domain only if it contains a '.' or ':' or is
+// 'localhost'. Docker Hub official images get the 'library/' prefix.
+func splitImageDomain(image string) (domain, remainder string) {
+	i := strings.IndexRune(image, '/')
+	if i == -1 || (!strings.ContainsAny(image[:i], ".:") && image[:i] != "localhost") {
+		domain, remainder = defaultRegistry, image
+	} else {
+		domain, remainder = normalizeRegistry(image[:i]), image[i+1:]
+	}
+
+	if domain == defaultRegistry && !strings.ContainsRune(remainder, '/') {
+		remainder = "library/" + remainder
+	}
+	return domain, remainder
+}
+
+func normalizeRegistry(registry string) string {
+	if registry == legacyDefaultRegistry {
+		return defaultRegistry
+	}
+	return registry
+}
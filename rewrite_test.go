@@ -0,0 +1,53 @@
+package testingdock_test
+
+import (
+	"testing"
+
+	"github.com/piotrkowalczuk/testingdock"
+)
+
+func TestRewriteMirror(t *testing.T) {
+	rewrite := testingdock.RewriteMirror("docker.io", "mirror.example.com/")
+
+	cases := map[string]string{
+		"postgres:9.6":                    "mirror.example.com/library/postgres:9.6",
+		"piotrkowalczuk/mnemosyne:v0.8.4": "mirror.example.com/piotrkowalczuk/mnemosyne:v0.8.4",
+		"index.docker.io/library/redis":   "mirror.example.com/library/redis",
+		"localhost/app":                   "",
+		"quay.io/hans/myimage:latest":     "",
+	}
+	for given, expected := range cases {
+		got, ok := rewrite(given)
+		if expected == "" {
+			if ok {
+				t.Errorf("%s: should not match, got %s", given, got)
+			}
+			continue
+		}
+		if !ok || got != expected {
+			t.Errorf("%s: wrong rewrite, expected %s but got %s", given, expected, got)
+		}
+	}
+}
+
+func TestParseRewriteRules(t *testing.T) {
+	rules, err := testingdock.ParseRewriteRules("prefix:quay.io/=mirror.example.com/quay/; regexp:^postgres:(.*)$=mirror.example.com/pg:$1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(rules) != 2 {
+		t.Fatalf("wrong number of rules, expected 2 but got %d", len(rules))
+	}
+	if got, _ := rules[0]("quay.io/hans/myimage"); got != "mirror.example.com/quay/hans/myimage" {
+		t.Errorf("wrong prefix rewrite: %s", got)
+	}
+	if got, _ := rules[1]("postgres:9.6"); got != "mirror.example.com/pg:9.6" {
+		t.Errorf("wrong regexp rewrite: %s", got)
+	}
+
+	for _, given := range []string{"prefix:quay.io", "unknown:a=b", "regexp:(=b", ":a=b"} {
+		if _, err := testingdock.ParseRewriteRules(given); err == nil {
+			t.Errorf("%s: expected error", given)
+		}
+	}
+}
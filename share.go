@@ -0,0 +1,347 @@
+package testingdock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// SharedDir is the directory the state of shared suites is written to, see
+// SuiteOpts.Shared.
+var SharedDir = filepath.Join(os.TempDir(), "testingdock")
+
+// sharedState is the state of a shared suite, written by the process which
+// started it and updated by every process attaching or detaching.
+type sharedState struct {
+	// Hash of the configuration of the network and containers
+	Hash    string `json:"hash"`
+	Network string `json:"network"`
+	// Containers maps the names to the IDs
+	Containers map[string]string `json:"containers"`
+	// Endpoints maps the names to the host addresses of the published ports
+	Endpoints map[string]map[string]string `json:"endpoints"`
+	// PIDs of the processes using the suite
+	PIDs []int `json:"pids"`
+	// Kept is set if a process detached, which kept the suite, so the last
+	// one does not tear it down and the next process attaches to it
+	Kept bool `json:"kept,omitempty"`
+}
+
+// startShared starts the suite or attaches to it, if it was started by
+// another process with the same configuration.
+func (s *Suite) startShared(ctx context.Context) {
+	unlock, err := lockShared(ctx, s.sharedPath(".lock"))
+	if err != nil {
+		s.t.Fatalf("shared suite lock failure: %s", err.Error())
+	}
+	defer unlock()
+
+	hash, err := s.sharedHash()
+	if err != nil {
+		s.t.Fatalf("shared suite configuration hashing failure: %s", err.Error())
+	}
+	state, err := s.readShared()
+	if err != nil {
+		s.t.Fatalf("shared suite state reading failure: %s", err.Error())
+	}
+
+	if state != nil && len(state.PIDs) > 0 {
+		if state.Hash != hash {
+			s.t.Fatalf("suite %s is shared by processes %v with a different configuration", s.name, state.PIDs)
+		}
+		if err = s.attach(ctx, state.Containers); err != nil {
+			s.t.Fatalf("shared suite attach failure: %s", err.Error())
+		}
+		s.startWatch()
+		state.PIDs = append(state.PIDs, os.Getpid())
+		if err = s.writeShared(state); err != nil {
+			s.t.Fatalf("shared suite state writing failure: %s", err.Error())
+		}
+		printf("(share ) %-25s - suite attached, shared by processes: %v", s.name, state.PIDs)
+		return
+	}
+	if state != nil && state.Kept && state.Hash == hash {
+		if s.attachKept(ctx, state) {
+			return
+		}
+	}
+
+	// nobody uses the suite (anymore), leftovers are removed on start
+	s.start(ctx)
+	state = &sharedState{
+		Hash:       hash,
+		Containers: make(map[string]string),
+		Endpoints:  make(map[string]map[string]string),
+		PIDs:       []int{os.Getpid()},
+	}
+	if s.network != nil {
+		state.Network = s.network.id
+		for _, c := range s.network.containers() {
+			state.Containers[c.Name] = c.ID
+			if state.Endpoints[c.Name], err = c.Endpoints(ctx); err != nil {
+				s.t.Fatalf("container inspect failure: %s", err.Error())
+			}
+		}
+	}
+	if err = s.writeShared(state); err != nil {
+		s.t.Fatalf("shared suite state writing failure: %s", err.Error())
+	}
+	printf("(share ) %-25s - suite started, state written to: %s", s.name, s.sharedPath(".json"))
+}
+
+// attachKept attaches to the suite kept by the last process, which used it,
+// unless its containers were removed or replaced in the meantime. The keep
+// mode of the process decides again, whether the suite is torn down on close.
+func (s *Suite) attachKept(ctx context.Context, state *sharedState) bool {
+	if err := s.attach(ctx, state.Containers); err != nil {
+		printf("(share ) %-25s - kept suite not attached, started instead: %s", s.name, err)
+		s.attached = false
+		return false
+	}
+	s.startWatch()
+	state.PIDs = []int{os.Getpid()}
+	state.Kept = false
+	if err := s.writeShared(state); err != nil {
+		s.t.Fatalf("shared suite state writing failure: %s", err.Error())
+	}
+	printf("(share ) %-25s - kept suite attached", s.name)
+	return true
+}
+
+// detachShared removes the process from the users of the shared suite and
+// records, if the process keeps the suite. Returns true if it was the last
+// one, which has to tear the suite down, unless any process kept it, before
+// releasing the lock with the returned function. The process, which started
+// the suite, is the last one if the state was removed.
+func (s *Suite) detachShared(ctx context.Context, keep bool) (last, kept bool, unlock func(), err error) {
+	unlock, err = lockShared(ctx, s.sharedPath(".lock"))
+	if err != nil {
+		return false, false, nil, err
+	}
+
+	state, err := s.readShared()
+	if err != nil {
+		unlock()
+		return false, false, nil, err
+	}
+	if state == nil {
+		if s.attached {
+			unlock()
+			return false, false, nil, nil
+		}
+		printf("(share ) %-25s - suite state missing, detached as the last process", s.name)
+		return true, keep, unlock, nil
+	}
+	pids := state.PIDs[:0]
+	for _, pid := range state.PIDs {
+		if pid != os.Getpid() {
+			pids = append(pids, pid)
+		}
+	}
+	state.PIDs = pids
+	state.Kept = state.Kept || keep
+
+	if len(state.PIDs) > 0 {
+		err = s.writeShared(state)
+		unlock()
+		printf("(share ) %-25s - suite detached, still shared by processes: %v", s.name, state.PIDs)
+		return false, keep, nil, err
+	}
+	// the state of a kept suite stays, so the next process attaches to it
+	if state.Kept {
+		err = s.writeShared(state)
+	} else {
+		err = os.Remove(s.sharedPath(".json"))
+	}
+	if err != nil {
+		unlock()
+		return false, false, nil, err
+	}
+	printf("(share ) %-25s - suite detached by the last process", s.name)
+	return true, state.Kept, unlock, nil
+}
+
+// sharedHash returns the hash of the configuration of the network and the
+// containers, processes only share suites with the same hash.
+func (s *Suite) sharedHash() (string, error) {
+	if s.network == nil {
+		return "", nil
+	}
+	var hashes []string
+	for _, c := range s.network.containers() {
+		ccfg := *c.ccfg
+		ccfg.Image = c.image
+		hash, err := configHash(&ccfg, c.hcfg)
+		if err != nil {
+			return "", err
+		}
+		hashes = append(hashes, c.Name+"="+hash)
+	}
+	sort.Strings(hashes)
+	sum := sha256.Sum256([]byte(s.network.name + "/" + s.network.subnet + "\n" + strings.Join(hashes, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// sharedPath returns the path of a file of the shared suite with the given
+// extension.
+func (s *Suite) sharedPath(ext string) string {
+	return filepath.Join(SharedDir, s.name+ext)
+}
+
+// readShared reads the state of the shared suite, without the processes,
+// which are not alive anymore. Returns nil if there is no state.
+func (s *Suite) readShared() (*sharedState, error) {
+	data, err := ioutil.ReadFile(s.sharedPath(".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state sharedState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	// processes killed without detaching
+	pids := state.PIDs[:0]
+	for _, pid := range state.PIDs {
+		if processAlive(pid) {
+			pids = append(pids, pid)
+		}
+	}
+	state.PIDs = pids
+	return &state, nil
+}
+
+// writeShared writes the state of the shared suite.
+func (s *Suite) writeShared(state *sharedState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written and renamed, so the state is never read half written
+	tmp := s.sharedPath(".json." + strconv.Itoa(os.Getpid()))
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.sharedPath(".json"))
+}
+
+// processAlive returns true if the process with the pid is running.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// Endpoints returns the host addresses of the published container ports by
+// the container ports, e.g. "5432/tcp" => "127.0.0.1:32768". It works for
+// attached containers as well, whose ports are not known in advance.
+func (c *Container) Endpoints(ctx context.Context) (map[string]string, error) {
+	cjson, err := c.Inspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make(map[string]string)
+	if cjson.NetworkSettings != nil {
+		for port, bindings := range cjson.NetworkSettings.Ports {
+			for _, b := range bindings {
+				host := b.HostIP
+				if host == "" || host == "0.0.0.0" {
+					host = "127.0.0.1"
+				}
+				endpoints[string(port)] = net.JoinHostPort(host, b.HostPort)
+				break
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// attach takes over the network and the containers of the suite started by
+// someone else by their names, instead of creating them. A container, which
+// does not exist by its name, is looked up by the label of the docker compose
+// service with the same name. If the IDs are given, the containers must not
+// have been replaced. The containers have to be running, except for jobs, and
+// healthy. Attached resources are never stopped or removed on close.
+func (s *Suite) attach(ctx context.Context, ids map[string]string) error {
+	if s.network == nil {
+		return nil
+	}
+	n := s.network
+	ni, err := s.cli.NetworkInspect(ctx, n.name, false)
+	if err != nil {
+		return err
+	}
+	n.id = ni.ID
+	if len(ni.IPAM.Config) > 0 {
+		n.gateway = ni.IPAM.Config[0].Gateway
+	}
+
+	containers := n.containers()
+	for _, c := range containers {
+		cjson, err := s.lookup(ctx, c.Name)
+		if err != nil {
+			return err
+		}
+		if id, ok := ids[c.Name]; ok && id != cjson.ID {
+			return fmt.Errorf("container %s was replaced", c.Name)
+		}
+		if !c.job && !cjson.State.Running {
+			return fmt.Errorf("container %s is not running", c.Name)
+		}
+		c.ID = cjson.ID
+		printf("(attach) %-25s (%s) - container attached", c.Name, c.ID)
+	}
+	s.attached = true
+
+	return forEach(containers, func(c *Container) error {
+		if c.job {
+			return nil
+		}
+		if err := c.waitHealthy(ctx); err != nil {
+			return fmt.Errorf("container %s health check failure: %s", c.Name, err)
+		}
+		return nil
+	})
+}
+
+// lookup inspects the container with the name or, if there is none, the one
+// of the docker compose service with the name.
+func (s *Suite) lookup(ctx context.Context, name string) (types.ContainerJSON, error) {
+	cjson, err := s.cli.ContainerInspect(ctx, name)
+	if err == nil || !client.IsErrContainerNotFound(err) {
+		return cjson, err
+	}
+	args := filters.NewArgs()
+	args.Add("label", "com.docker.compose.service="+name)
+	containers, lerr := s.cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: args})
+	if lerr != nil {
+		return cjson, lerr
+	}
+	switch len(containers) {
+	case 0:
+		return cjson, err
+	case 1:
+		return s.cli.ContainerInspect(ctx, containers[0].ID)
+	}
+	return cjson, fmt.Errorf("container %s is ambiguous, %d docker compose services found", name, len(containers))
+}
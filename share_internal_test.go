@@ -0,0 +1,54 @@
+//go:build !windows
+// +build !windows
+
+package testingdock
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLockShared")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	name := filepath.Join(dir, "suite.lock")
+
+	// an empty lock file left by a crashed process is no lock
+	if err = ioutil.WriteFile(name, nil, 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	unlock, err := lockShared(context.TODO(), name)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 300*time.Millisecond)
+	defer cancel()
+	if _, err = lockShared(ctx, name); err != context.DeadlineExceeded {
+		t.Fatalf("expected the lock to be held, got: %v", err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		unlock2, err := lockShared(context.TODO(), name)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else {
+			unlock2()
+		}
+		close(locked)
+	}()
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not released")
+	}
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+// +build !windows
+
+package testingdock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// lockShared takes an exclusive flock of the lock file, waiting until the
+// process holding it releases it. The kernel releases the lock of a process,
+// which died, so there are no stale locks. The lock is released with the
+// returned function, the file is never removed, since another process may
+// be waiting for a lock on it.
+func lockShared(ctx context.Context, name string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return func() {
+				syscall.Flock(int(f.Fd()), syscall.LOCK_UN) // nolint: errcheck
+				f.Close()                                   // nolint: errcheck
+			}, nil
+		}
+		if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			f.Close() // nolint: errcheck
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			f.Close() // nolint: errcheck
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package testingdock
+
+import (
+	"context"
+	"errors"
+)
+
+// lockShared is not supported on windows, which has no flock.
+func lockShared(ctx context.Context, name string) (func(), error) {
+	return nil, errors.New("shared suites are not supported on windows")
+}
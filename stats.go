@@ -0,0 +1,142 @@
+package testingdock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// Limits are the resource limits of a container. Zero values mean no limit.
+type Limits struct {
+	// Memory limit in bytes
+	Memory int64
+	// CPUs is the number of CPUs the container may use, e.g. 0.5
+	CPUs float64
+	// Pids is the maximum number of processes
+	Pids int64
+}
+
+// Limit presets for typical containers.
+var (
+	LimitsSmall  = Limits{Memory: 256 << 20, CPUs: 0.5, Pids: 256}
+	LimitsMedium = Limits{Memory: 1 << 30, CPUs: 1, Pids: 1024}
+	LimitsLarge  = Limits{Memory: 4 << 30, CPUs: 2, Pids: 4096}
+)
+
+// apply sets the limits in the host configuration, without overriding
+// limits set there explicitly.
+func (l Limits) apply(hcfg *container.HostConfig) {
+	if hcfg.Memory == 0 {
+		hcfg.Memory = l.Memory
+	}
+	if hcfg.NanoCPUs == 0 {
+		hcfg.NanoCPUs = int64(l.CPUs * 1e9)
+	}
+	if hcfg.PidsLimit == 0 {
+		hcfg.PidsLimit = l.Pids
+	}
+}
+
+// Stats is the resource usage of a container, sampled while the suite runs.
+type Stats struct {
+	// PeakMemory is the highest memory usage in bytes
+	PeakMemory uint64
+	// CPU is the CPU time used by the container
+	CPU time.Duration
+	// NetworkRx and NetworkTx are the bytes received and sent
+	NetworkRx, NetworkTx uint64
+	// Samples is the number of samples taken
+	Samples int
+}
+
+// String implements fmt.Stringer interface.
+func (s Stats) String() string {
+	return fmt.Sprintf("peak memory %.1f MiB, cpu %s, network rx %d B, tx %d B",
+		float64(s.PeakMemory)/(1<<20), s.CPU, s.NetworkRx, s.NetworkTx)
+}
+
+// Stats returns the resource usage of the container sampled so far.
+func (c *Container) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// startSampling starts sampling the resource usage of the current container,
+// independent of the context it was started with.
+func (c *Container) startSampling() {
+	if c.statsCancel != nil {
+		c.statsCancel()
+	}
+	var ctx context.Context
+	ctx, c.statsCancel = context.WithCancel(context.Background())
+	c.sampleStats(ctx)
+}
+
+// sampleStats samples the resource usage of the container until it is
+// removed or the context is cancelled. The counters of docker start at
+// zero for every container and restart, so only the increments are added up.
+func (c *Container) sampleStats(ctx context.Context) {
+	res, err := c.cli.ContainerStats(ctx, c.ID, true)
+	if err != nil {
+		printf("(stats ) %-25s (%s) - container stats failure: %s", c.Name, c.ID, err)
+		return
+	}
+
+	go func() {
+		defer res.Body.Close() // nolint: errcheck
+
+		var last struct{ cpu, rx, tx uint64 }
+		dec := json.NewDecoder(res.Body)
+		for {
+			var s types.StatsJSON
+			if err := dec.Decode(&s); err != nil {
+				return // container removed or context cancelled
+			}
+
+			var rx, tx uint64
+			for _, n := range s.Networks {
+				rx += n.RxBytes
+				tx += n.TxBytes
+			}
+
+			c.mu.Lock()
+			if s.MemoryStats.Usage > c.stats.PeakMemory {
+				c.stats.PeakMemory = s.MemoryStats.Usage
+			}
+			c.stats.CPU += time.Duration(increment(last.cpu, s.CPUStats.CPUUsage.TotalUsage))
+			c.stats.NetworkRx += increment(last.rx, rx)
+			c.stats.NetworkTx += increment(last.tx, tx)
+			c.stats.Samples++
+			c.mu.Unlock()
+
+			last.cpu, last.rx, last.tx = s.CPUStats.CPUUsage.TotalUsage, rx, tx
+		}
+	}()
+}
+
+// increment returns the increment of a counter, which is reset to zero if
+// the container restarts.
+func increment(last, cur uint64) uint64 {
+	if cur < last {
+		return cur
+	}
+	return cur - last
+}
+
+// printStats prints the resource usage of all containers.
+func (s *Suite) printStats() {
+	if s.network == nil {
+		return
+	}
+	containers := s.network.containers()
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	for _, c := range containers {
+		printf("(stats ) %-25s (%s) - %s", c.Name, c.ID, c.Stats())
+	}
+}
@@ -4,25 +4,44 @@
 // 'owner=testingdock', which may be subject to aggressive manipulation
 // and cleanup.
 //
+// Image references can be rewritten, e.g. to pull through a registry mirror,
+// via SuiteOpts.Rewrite or the TESTINGDOCK_IMAGE_REWRITE environment variable.
+//
 // Testingdock also makes use of the 'flag' package to set global variables.
 // Run `flag.Parse()` in your test suite main function. Possible flags are:
 //  -testingdock.sequential (spawn containers sequentially instead of parallel)
+//  -testingdock.parallelism (maximum number of containers started at the same time)
 //  -testingdock.verbose (verbose logging)
+//  -testingdock.stats (print the resource usage of the containers on close)
+//  -testingdock.timings (print the time spent in each lifecycle phase on close)
+//  -testingdock.timings-dir (write the lifecycle timings as JSON to the directory on close)
+//  -testingdock.trace-dir (write the lifecycle traces in the Chrome trace event format to the directory on close)
+//  -testingdock.keep (keep containers alive after close: never, on-failure or always)
 package testingdock
 
 import (
 	"context"
 	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/daemon/logger"
 )
 
 func init() {
 	registry = make(map[string]*Suite)
 	flag.BoolVar(&SpawnSequential, "testingdock.sequential", false, "Spawn containers sequentially instead of parallel (useful for debugging)")
 	flag.BoolVar(&Verbose, "testingdock.verbose", false, "Verbose logging")
+	flag.IntVar(&Parallelism, "testingdock.parallelism", 0, "Maximum number of containers started, reset or stopped at the same time, 0 means no limit")
+	flag.BoolVar(&PrintStats, "testingdock.stats", false, "Print the resource usage of the containers on close")
+	flag.BoolVar(&PrintTimings, "testingdock.timings", false, "Print the time spent in each lifecycle phase on close")
+	flag.StringVar(&TimingsDir, "testingdock.timings-dir", "", "Directory the lifecycle timings are written to as JSON on close")
+	flag.StringVar(&TraceDir, "testingdock.trace-dir", "", "Directory the lifecycle traces are written to in the Chrome trace event format on close")
+	flag.Var(&Keep, "testingdock.keep", "Keep containers alive after close for debugging: never, on-failure or always")
 }
 
 var registry map[string]*Suite
@@ -38,30 +57,113 @@ var registry map[string]*Suite
 //  c1.After(c4)
 var SpawnSequential bool
 
+// Parallelism limits the number of containers started, reset or stopped at
+// the same time, 0 means no limit. It has to be set before the first suite is
+// started.
+var Parallelism int
+
+var (
+	slotsOnce sync.Once
+	slots     chan struct{}
+)
+
 // Verbose logging
 var Verbose bool
 
+// PrintStats prints the resource usage of the containers on close, see
+// Container.Stats.
+var PrintStats bool
+
+// PrintTimings prints a table of the time spent in each lifecycle phase on
+// close, see Suite.Timings.
+var PrintTimings bool
+
+// TimingsDir is the directory the lifecycle timings of every suite are
+// written to as JSON on close, if set.
+var TimingsDir string
+
+// TraceDir is the directory the lifecycle trace of every suite is written to
+// on close, in the Chrome trace event format, see ChromeTrace. It has to be
+// set before the suites are created.
+var TraceDir string
+
 // SuiteOpts is an option struct for getting or creating a suite in GetOrCreateSuite.
 type SuiteOpts struct {
 	// optional docker client, if one already exists
 	Client *client.Client
 	// whether to fail on instantiation errors
 	Skip bool
+	// image rewrite rules, applied after the ones from the
+	// TESTINGDOCK_IMAGE_REWRITE environment variable; the first
+	// matching rule wins
+	Rewrite []RewriteFunc
+	// whether to keep the containers alive after close, overrides
+	// the -testingdock.keep flag if set
+	Keep KeepMode
+	// hooks called during the lifecycle of the suite
+	Hooks SuiteHooks
+	// optional tracer, which receives the spans of the suite start and
+	// the lifecycle phases of the network and containers
+	Tracer Tracer
+	// Shared shares the suite with other processes, e.g. the test binaries
+	// of all packages run by go test ./..., instead of starting it in every
+	// process. The first process starts the suite and writes its state to
+	// SharedDir, the others attach to it, if their configuration is the
+	// same, and the last process to close it tears it down. Host ports have
+	// to be the same as well, leave them empty instead of using RandomPort.
+	// Resets affect all processes sharing the suite. If any process keeps
+	// the suite, see Keep, the last one does not tear it down and the next
+	// run attaches to it, unless its containers are gone.
+	Shared bool
+	// Attach attaches to the network and containers started by someone
+	// else, e.g. a CI pipeline step, instead of creating them. Start looks
+	// them up by name, or by the docker compose service label, and waits
+	// until they are healthy. Close only removes what the suite created
+	// itself, e.g. proxies and netem rules. Resets do not reset the
+	// containers either, see Suite.Reset. The events of the containers are
+	// watched like the ones of started containers, see Suite.Events and
+	// Suite.Err. Attach takes precedence over Shared.
+	Attach bool
 }
 
 // Suite represents a testing suite with a docker setup.
 type Suite struct {
-	name       string
-	t          testing.TB
-	cli        *client.Client
-	network    *Network
-	logWatcher *logger.LogWatcher
+	name    string
+	t       testing.TB
+	cli     *client.Client
+	network *Network
+	rewrite []RewriteFunc
+	keep    KeepMode
+	kept    bool
+	closed  bool
+	hooks   SuiteHooks
+	tracers []Tracer
+	chrome  *ChromeTrace
+	// shared with other processes, attached if started by another one
+	shared   bool
+	attached bool
+	// attach to resources started outside of testingdock
+	external bool
+
+	watchCancel func()
+	// guards the crashes, proxies, images and tracked tests
+	mu       sync.Mutex
+	tracked  []testing.TB
+	crashes  []*Crash
+	reported int
+	proxies  []*Proxy
+	images   []string
+	// guards the event subscribers
+	subMu       sync.Mutex
+	subscribers []*subscriber
 }
 
 // GetOrCreateSuite returns a suite with the given name. If such suite is not registered yet it creates it.
-// Returns true if the suite was already there, otherwise false.
+// Returns true if the suite was already there, otherwise false. The test getting an existing suite is
+// tracked, see Suite.Track.
 func GetOrCreateSuite(t testing.TB, name string, opts SuiteOpts) (*Suite, bool) {
 	if s, ok := registry[name]; ok {
+		s.Track(t)
 		return s, true
 	}
 
@@ -78,10 +180,27 @@ func GetOrCreateSuite(t testing.TB, name string, opts SuiteOpts) (*Suite, bool)
 		}
 	}
 
+	rewrite, err := ParseRewriteRules(os.Getenv(RewriteEnv))
+	if err != nil {
+		t.Fatalf("image rewrite rules parsing failure: %s", err.Error())
+	}
+
 	s := &Suite{
-		cli:  c,
-		t:    t,
-		name: name,
+		cli:      c,
+		t:        t,
+		name:     name,
+		rewrite:  append(rewrite, opts.Rewrite...),
+		keep:     opts.Keep,
+		hooks:    opts.Hooks,
+		shared:   opts.Shared && !opts.Attach,
+		external: opts.Attach,
+	}
+	if opts.Tracer != nil {
+		s.tracers = append(s.tracers, opts.Tracer)
+	}
+	if TraceDir != "" {
+		s.chrome = NewChromeTrace()
+		s.tracers = append(s.tracers, s.chrome)
 	}
 	registry[s.name] = s
 	return s, false
@@ -104,15 +223,39 @@ func UnregisterAll() {
 
 // Container creates a new docker container configuration with the given options.
 func (s *Suite) Container(opts ContainerOpts) *Container {
-	return newContainer(s.t, s.cli, opts)
+	c := newContainer(s.t, s.cli, opts)
+	c.suite = s
+	c.image = rewriteImage(s.rewrite, c.Image)
+	return c
 }
 
 // Network creates a new docker network configuration with the given options.
 func (s *Suite) Network(opts NetworkOpts) *Network {
 	s.network = newNetwork(s.t, s.cli, opts)
+	s.network.suite = s
+	s.network.labels[SuiteLabel] = s.name
 	return s.network
 }
 
+// Name returns the name of the suite.
+func (s *Suite) Name() string {
+	return s.name
+}
+
+// ContainerByName returns the container of the suite with the given name,
+// or nil if there is no such container.
+func (s *Suite) ContainerByName(name string) *Container {
+	if s.network == nil {
+		return nil
+	}
+	for _, c := range s.network.containers() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
 // Reset "resets" the underlying docker containers in the network. This
 // calls the ResetFunc and HealthCheckFunc for each of them. These can be passed in
 // ContainerOpts when creating a container.
@@ -120,44 +263,297 @@ func (s *Suite) Network(opts NetworkOpts) *Network {
 // The context is passed explicitly to ResetFunc, where it can be used and
 // implicitly to HealthCheckFunc where it may cancel the blocking health
 // check loop.
+//
+// Containers which died unexpectedly since the last reset fail the test,
+// which created the suite. The toxics of all proxies and the netem rules of
+// all containers are cleared.
+//
+// The containers of a suite attached with SuiteOpts.Attach are not reset,
+// only the changes of the suite itself are undone: the proxies and netem
+// rules are cleared and the network partitions healed.
 func (s *Suite) Reset(ctx context.Context) {
-	if s.network != nil {
+	s.reportCrashes()
+	s.clearProxies()
+	if err := s.clearNetEm(ctx); err != nil {
+		s.t.Fatalf("%s", err.Error())
+	}
+
+	s.mustHook(ctx, "BeforeReset", s.hooks.BeforeReset)
+	if s.external {
+		s.heal(ctx)
+	} else if s.network != nil {
 		s.network.reset(ctx)
 	}
+	s.mustHook(ctx, "AfterReset", s.hooks.AfterReset)
 }
 
-// Start starts the suite. This starts all networks in the suite and the underlying containers,
-// as well as the daemon logger, if Verbosity is enabled.
-func (s *Suite) Start(ctx context.Context) {
-	if s.logWatcher == nil && Verbose {
-		printf("(daemon) starting logging")
-		s.logWatcher = logger.NewLogWatcher()
-		go func() {
-			for {
-				select {
-				case <-ctx.Done():
-					printf("(daemon) stopping logging")
-					s.logWatcher.Close()
-					return
-				case msg := <-s.logWatcher.Msg:
-					printf("(daemon) %s", msg.Line)
-				case err := <-s.logWatcher.Err:
-					printf("(d err ) %s", err)
-				}
+// ResetContainers resets only the containers with the given names, together
+// with their children, which depend on them. Siblings are reset in parallel,
+// like in Reset. Attached containers are not reset, see Reset.
+func (s *Suite) ResetContainers(ctx context.Context, names ...string) {
+	var containers []*Container
+	for _, name := range names {
+		c := s.ContainerByName(name)
+		if c == nil {
+			s.t.Fatalf("container %s not found in suite %s", name, s.name)
+		}
+		containers = append(containers, c)
+	}
+	s.resetContainers(ctx, containers)
+}
+
+// ResetDirty resets only the containers marked with Container.MarkDirty since
+// their last reset, together with their children. Attached containers are not
+// reset, see Reset.
+func (s *Suite) ResetDirty(ctx context.Context) {
+	var containers []*Container
+	if s.network != nil {
+		for _, c := range s.network.containers() {
+			if c.isDirty() {
+				containers = append(containers, c)
 			}
-		}()
+		}
+	}
+	s.resetContainers(ctx, containers)
+}
+
+// resetContainers resets the given containers, skipping the ones which are
+// reset anyway as descendant of another one.
+func (s *Suite) resetContainers(ctx context.Context, containers []*Container) {
+	s.reportCrashes()
+
+	descendants := make(map[*Container]bool)
+	for _, c := range containers {
+		for _, d := range c.tree()[1:] {
+			descendants[d] = true
+		}
 	}
+	var roots []*Container
+	for _, c := range containers {
+		if !descendants[c] {
+			descendants[c] = true // skip duplicates
+			roots = append(roots, c)
+		}
+	}
+
+	s.mustHook(ctx, "BeforeReset", s.hooks.BeforeReset)
+	if s.external {
+		s.heal(ctx)
+	} else {
+		forEach(roots, func(c *Container) error { // nolint: errcheck
+			c.reset(ctx)
+			return nil
+		})
+	}
+	s.mustHook(ctx, "AfterReset", s.hooks.AfterReset)
+}
+
+// Start starts the suite. This starts all networks in the suite and the underlying containers.
+//
+// From now on, until the suite is closed, the lifecycle events of the containers are
+// watched (see Suite.Events) and logged, if Verbosity is enabled. Containers dying
+// unexpectedly are reported, see Suite.Err. A shared suite started by another
+// process is attached instead, see SuiteOpts.Shared and SuiteOpts.Attach.
+func (s *Suite) Start(ctx context.Context) {
+	if s.external {
+		if err := s.attach(ctx, nil); err != nil {
+			s.t.Fatalf("suite attach failure: %s", err.Error())
+		}
+		s.startWatch()
+		printf("(attach) %-25s - suite attached", s.name)
+		return
+	}
+	if s.shared {
+		s.startShared(ctx)
+		return
+	}
+	s.start(ctx)
+}
+
+// start creates and starts the network and containers.
+func (s *Suite) start(ctx context.Context) {
+	start := time.Now()
+	s.mustHook(ctx, "BeforeCreate", s.hooks.BeforeCreate)
+	s.startWatch()
+	s.mustHook(ctx, "AfterCreate", s.hooks.AfterCreate)
 
 	if s.network != nil {
 		s.network.start(ctx)
 	}
+	s.mustHook(ctx, "AfterStart", s.hooks.AfterStart)
+	s.mustHook(ctx, "AfterHealthy", s.hooks.AfterHealthy)
+
+	s.trace(TraceSpan{
+		Name:       "suite.start",
+		Start:      start,
+		End:        time.Now(),
+		Attributes: map[string]string{"suite": s.name},
+	})
 }
 
-// Close stops the suites. This stops all networks in the suite and the underlying containers.
+// startWatch starts watching the events of the suite, unless it is watched
+// already, until the suite is closed.
+func (s *Suite) startWatch() {
+	if s.watchCancel != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, s.watchCancel = context.WithCancel(context.Background())
+	s.watch(ctx)
+}
+
+// Close stops the suites, see CloseContext.
 func (s *Suite) Close() error {
+	return s.CloseContext(context.Background())
+}
+
+// CloseContext stops the suites. This gracefully stops all containers in the
+// suite, children before their parents, and then removes them and the networks.
+// Depending on the keep mode, the suite may be kept alive instead, in which case
+// the information needed to inspect the containers is printed.
+//
+// An error is returned if a container created with CleanExit did not exit
+// with code zero, or if a stop or close hook failed. Containers, which died
+// unexpectedly since the last reset, fail the test, see Suite.Err.
+func (s *Suite) CloseContext(ctx context.Context) error {
+	if s.watchCancel != nil {
+		s.watchCancel()
+	}
+	s.reportCrashes()
+	if s.kept || s.closed {
+		return nil
+	}
+	if s.external {
+		s.closed = true
+		err := s.release(ctx)
+		printf("(attach) %-25s - suite detached, attached resources kept", s.name)
+		return err
+	}
+	if s.shared {
+		// detached first, so a kept suite is not torn down by the last process
+		last, kept, unlock, err := s.detachShared(ctx, s.shouldKeep())
+		if err != nil {
+			return fmt.Errorf("shared suite detach failure: %s", err)
+		}
+		if unlock != nil {
+			defer unlock()
+		}
+		switch {
+		case kept:
+			s.kept = true
+			s.printKept(ctx)
+			return nil
+		case !last:
+			s.closed = true
+			return s.release(ctx)
+		case s.attached:
+			// started by another process, so it is torn down by name
+			s.closed = true
+			err = s.release(ctx)
+			s.Down(ctx)
+			return err
+		}
+	} else if s.shouldKeep() {
+		s.kept = true
+		s.printKept(ctx)
+		return nil
+	}
+
+	s.closed = true
+	err := s.hook(ctx, "BeforeStop", s.hooks.BeforeStop)
+	s.closeProxies()
+	// reused containers are kept running
+	if nerr := s.clearNetEm(ctx); nerr != nil && err == nil {
+		err = nerr
+	}
 	if s.network != nil {
-		return s.network.close()
+		if nerr := s.network.close(ctx); nerr != nil {
+			err = nerr
+		}
 	}
+	if ierr := s.removeImages(ctx); ierr != nil && err == nil {
+		err = fmt.Errorf("image removal failure: %s", ierr)
+	}
+	if PrintStats {
+		s.printStats()
+	}
+	if PrintTimings {
+		s.printTimings()
+	}
+	if TimingsDir != "" {
+		if terr := s.writeTimings(TimingsDir); terr != nil && err == nil {
+			err = terr
+		}
+	}
+	if s.chrome != nil {
+		name := filepath.Join(TraceDir, s.name+".trace.json")
+		if terr := s.chrome.WriteFile(name); terr != nil {
+			if err == nil {
+				err = terr
+			}
+		} else {
+			printf("(timing) %-25s - trace written to: %s", s.name, name)
+		}
+	}
+	if herr := s.hook(ctx, "AfterClose", s.hooks.AfterClose); herr != nil && err == nil {
+		err = herr
+	}
+
+	return err
+}
+
+// release removes what the suite added to attached resources, which are
+// not torn down: the proxies, the netem rules and the partitions.
+func (s *Suite) release(ctx context.Context) error {
+	s.closeProxies()
+	err := s.clearNetEm(ctx)
+	if s.network != nil {
+		if herr := s.network.Heal(ctx); herr != nil && err == nil {
+			err = fmt.Errorf("network heal failure: %s", herr)
+		}
+	}
+	return err
+}
+
+// heal removes the network partitions, instead of resetting the containers
+// of an attached suite.
+func (s *Suite) heal(ctx context.Context) {
+	if s.network == nil {
+		return
+	}
+	if err := s.network.Heal(ctx); err != nil {
+		s.t.Fatalf("network heal failure: %s", err.Error())
+	}
+}
+
+// clearProxies removes the toxics of all proxies.
+func (s *Suite) clearProxies() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.proxies {
+		p.Clear()
+	}
+}
 
+// closeProxies closes all proxies.
+func (s *Suite) closeProxies() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.proxies {
+		p.Close() // nolint: errcheck
+	}
+	s.proxies = nil
+}
+
+// clearNetEm removes the netem rules of all containers.
+func (s *Suite) clearNetEm(ctx context.Context) error {
+	if s.network == nil {
+		return nil
+	}
+	for _, c := range s.network.containers() {
+		if err := c.ClearNetEm(ctx); err != nil {
+			return fmt.Errorf("netem rules removal failure: %s", err)
+		}
+	}
 	return nil
 }
@@ -3,9 +3,18 @@ package testingdock_test
 import (
 	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
 	"github.com/piotrkowalczuk/testingdock"
 )
 
@@ -35,3 +44,715 @@ func TestGetOrCreateSuite(t *testing.T) {
 
 	testingdock.UnregisterAll()
 }
+
+func TestSuite_Err(t *testing.T) {
+	// the crash fails the test on close
+	ft := &fatalT{TB: t}
+	s, ok := testingdock.GetOrCreateSuite(ft, "TestSuite_Err", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	n := s.Network(testingdock.NetworkOpts{Name: "TestSuite_Err"})
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Err_alpine",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sh", "-c", "echo going down; sleep 3; exit 3"},
+		},
+	}))
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	time.Sleep(5 * time.Second)
+
+	crash, ok := s.Err().(*testingdock.Crash)
+	if !ok {
+		t.Fatalf("expected crash, got: %v", s.Err())
+	}
+	if crash.ExitCode != 3 {
+		t.Errorf("wrong exit code, expected 3 but got %d", crash.ExitCode)
+	}
+	if len(crash.Logs) != 1 || crash.Logs[0] != "going down" {
+		t.Errorf("wrong logs: %v", crash.Logs)
+	}
+}
+
+func TestSuite_Close_crash(t *testing.T) {
+	ft := &fatalT{TB: t}
+	s, ok := testingdock.GetOrCreateSuite(ft, "TestSuite_Close_crash", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Close_crash",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		StopSignal: "SIGKILL",
+	})
+	s.Network(testingdock.NetworkOpts{Name: "TestSuite_Close_crash"}).After(c)
+	s.Start(context.TODO())
+
+	// killed behind the back of the suite, which is never reset
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err = cli.ContainerKill(context.TODO(), c.ID, "SIGKILL"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 50 && s.Err() == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if len(ft.failures) != 1 || !strings.Contains(ft.failures[0], "TestSuite_Close_crash") {
+		t.Errorf("expected the crash to fail the test on close, got: %v", ft.failures)
+	}
+}
+
+func TestSuite_Events(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_Events", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	n := s.Network(testingdock.NetworkOpts{Name: "TestSuite_Events"})
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Events_postgres",
+		Config: &container.Config{
+			Image: "postgres:9.6",
+		},
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	events := s.Events(ctx)
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	expected := []testingdock.EventType{testingdock.EventCreate, testingdock.EventConnect, testingdock.EventStart}
+	for _, typ := range expected {
+		e, ok := <-events
+		if !ok {
+			t.Fatalf("events channel closed, expected %s", typ)
+		}
+		if e.Type != typ || e.Container != "TestSuite_Events_postgres" {
+			t.Errorf("wrong event, expected %s of TestSuite_Events_postgres but got %s of %s", typ, e.Type, e.Container)
+		}
+	}
+}
+
+func TestSuite_CloseContext(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_CloseContext", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	n := s.Network(testingdock.NetworkOpts{Name: "TestSuite_CloseContext"})
+	graceful := s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_CloseContext_graceful",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sh", "-c", "trap 'exit 0' USR1; sleep 60 & wait"},
+		},
+		StopSignal: "SIGUSR1",
+		CleanExit:  true,
+	})
+	// sleep as PID 1 ignores SIGTERM and gets killed after the timeout
+	stubborn := s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_CloseContext_stubborn",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		StopTimeout: time.Second,
+		CleanExit:   true,
+	})
+	n.After(graceful)
+	graceful.After(stubborn)
+
+	s.Start(context.TODO())
+
+	if err := s.CloseContext(context.TODO()); err == nil {
+		t.Error("expected unclean exit error")
+	}
+	if graceful.ExitCode() != 0 {
+		t.Errorf("wrong exit code of the graceful container: %d", graceful.ExitCode())
+	}
+	if stubborn.ExitCode() != 137 {
+		t.Errorf("wrong exit code of the stubborn container: %d", stubborn.ExitCode())
+	}
+}
+
+func TestSuite_ResetDirty(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_ResetDirty", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	var mu sync.Mutex
+	resets := make(map[string]int)
+	opts := func(name string) testingdock.ContainerOpts {
+		return testingdock.ContainerOpts{
+			Name: name,
+			Config: &container.Config{
+				Image: "alpine:3.6",
+				Cmd:   []string{"sleep", "60"},
+			},
+			StopSignal: "SIGKILL",
+			Reset: func(ctx context.Context, c *testingdock.Container) error {
+				mu.Lock()
+				resets[c.Name]++
+				mu.Unlock()
+				return nil
+			},
+		}
+	}
+
+	n := s.Network(testingdock.NetworkOpts{Name: "TestSuite_ResetDirty"})
+	db := s.Container(opts("TestSuite_ResetDirty_db"))
+	app := s.Container(opts("TestSuite_ResetDirty_app"))
+	cache := s.Container(opts("TestSuite_ResetDirty_cache"))
+	n.After(db)
+	n.After(cache)
+	db.After(app)
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	db.MarkDirty()
+	s.ResetDirty(context.TODO())
+	// nothing is dirty anymore
+	s.ResetDirty(context.TODO())
+	s.ResetContainers(context.TODO(), cache.Name)
+
+	exp := map[string]int{db.Name: 1, app.Name: 1, cache.Name: 1}
+	for name, n := range exp {
+		if resets[name] != n {
+			t.Errorf("container %s expected to be reset %d times, but got %d", name, n, resets[name])
+		}
+	}
+}
+
+func TestSuite_Timings(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_Timings", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	n := s.Network(testingdock.NetworkOpts{Name: "TestSuite_Timings"})
+	opts := func(name string, delay time.Duration) testingdock.ContainerOpts {
+		return testingdock.ContainerOpts{
+			Name: name,
+			Config: &container.Config{
+				Image: "alpine:3.6",
+				Cmd:   []string{"sleep", "60"},
+			},
+			StopSignal: "SIGKILL",
+			HealthCheck: func(ctx context.Context, c *testingdock.Container) error {
+				time.Sleep(delay)
+				return nil
+			},
+		}
+	}
+	fast := s.Container(opts("TestSuite_Timings_fast", 0))
+	slow := s.Container(opts("TestSuite_Timings_slow", 2*time.Second))
+	child := s.Container(opts("TestSuite_Timings_child", 0))
+	n.After(fast)
+	n.After(slow)
+	slow.After(child)
+
+	s.Start(context.TODO())
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	timings := s.Timings()
+	if len(timings) != 4 {
+		t.Fatalf("wrong number of timings: %d", len(timings))
+	}
+	critical := make(map[string]bool)
+	for _, timing := range timings {
+		critical[timing.Name] = timing.Critical
+		if timing.Kind != "container" {
+			continue
+		}
+		for _, phase := range []testingdock.Phase{testingdock.PhaseCreate, testingdock.PhaseStart, testingdock.PhaseHealthy, testingdock.PhaseRemove} {
+			if timing.Total(phase) == 0 {
+				t.Errorf("phase %s of container %s not recorded", phase, timing.Name)
+			}
+		}
+	}
+	if !critical[slow.Name] || !critical[child.Name] || critical[fast.Name] {
+		t.Errorf("wrong critical path: %v", critical)
+	}
+	if d := timings[2].Total(testingdock.PhaseFirstProbe); d < 2*time.Second {
+		t.Errorf("wrong first probe duration: %s", d)
+	}
+}
+
+func TestSuite_Timings_criticalPath(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_Timings_criticalPath", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	n := s.Network(testingdock.NetworkOpts{Name: "TestSuite_Timings_criticalPath"})
+	opts := func(name string, delay time.Duration) testingdock.ContainerOpts {
+		return testingdock.ContainerOpts{
+			Name: name,
+			Config: &container.Config{
+				Image: "alpine:3.6",
+				Cmd:   []string{"sleep", "60"},
+			},
+			StopSignal: "SIGKILL",
+			HealthCheck: func(ctx context.Context, c *testingdock.Container) error {
+				time.Sleep(delay)
+				return nil
+			},
+		}
+	}
+	// early is ready before late, but its subtree takes longer
+	early := s.Container(opts("TestSuite_Timings_criticalPath_early", 0))
+	late := s.Container(opts("TestSuite_Timings_criticalPath_late", 2*time.Second))
+	deep := s.Container(opts("TestSuite_Timings_criticalPath_deep", 4*time.Second))
+	// waits for deep in another branch
+	waiting := s.Container(opts("TestSuite_Timings_criticalPath_waiting", 0))
+	n.After(early)
+	n.After(late)
+	early.After(deep)
+	late.After(waiting)
+	waiting.WaitFor(deep)
+
+	s.Start(context.TODO())
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	critical := make(map[string]bool)
+	for _, timing := range s.Timings() {
+		critical[timing.Name] = timing.Critical
+	}
+	if !critical[early.Name] || !critical[deep.Name] || !critical[waiting.Name] || critical[late.Name] {
+		t.Errorf("wrong critical path: %v", critical)
+	}
+}
+
+func TestSuite_Compose(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_Compose", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	compose := `
+services:
+  db:
+    image: alpine:3.6
+    command: sleep 60
+    healthcheck:
+      test: ["CMD", "true"]
+      interval: 1s
+  cache:
+    image: alpine:3.6
+    command: sleep 60
+  migrate:
+    image: alpine:3.6
+    command: sh -c "echo done > /data/migrated"
+    volumes:
+      - data:/data
+    depends_on: [db]
+  app:
+    image: alpine:3.6
+    command: sh -c "grep -q done /data/migrated && nslookup db && touch /tmp/ok && sleep 60"
+    healthcheck:
+      test: ["CMD", "test", "-f", "/tmp/ok"]
+      interval: 1s
+    volumes:
+      - data:/data:ro
+    depends_on:
+      cache:
+        condition: service_started
+      migrate:
+        condition: service_completed_successfully
+    networks:
+      default:
+        aliases: [api]
+volumes:
+  data:
+`
+	path := filepath.Join(dir, "docker-compose.yml")
+	if err = ioutil.WriteFile(path, []byte(compose), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var overridden []string
+	containers := s.Compose(context.TODO(), testingdock.ComposeOpts{
+		Path: path,
+		Override: func(service string, opts *testingdock.ContainerOpts) {
+			overridden = append(overridden, service)
+			opts.StopSignal = "SIGKILL"
+		},
+	})
+	if len(overridden) != 4 {
+		t.Fatalf("expected override of 4 services, got %v", overridden)
+	}
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	// the health check of app only succeeds with the migrated volume and db
+	cjson, err := containers["app"].Inspect(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if aliases := cjson.NetworkSettings.Networks["TestSuite_Compose"].Aliases; !containsString(aliases, "api") {
+		t.Errorf("expected alias api, got %v", aliases)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSuite_Compose_order(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_Compose_order", testingdock.SuiteOpts{})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	dir, err := ioutil.TempDir("", "testingdock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	// d depends on two branches, b is started after c depth-first, if d
+	// was started after c
+	compose := `
+services:
+  a:
+    image: alpine:3.6
+  b:
+    image: alpine:3.6
+  c:
+    image: alpine:3.6
+    depends_on: [a]
+  d:
+    image: alpine:3.6
+    depends_on: [b, c]
+`
+	path := filepath.Join(dir, "docker-compose.yml")
+	if err = ioutil.WriteFile(path, []byte(compose), 0644); err != nil {
+		t.Fatal(err)
+	}
+	containers := s.Compose(context.TODO(), testingdock.ComposeOpts{Path: path})
+
+	// the timings are in the sequential start order
+	position := make(map[string]int)
+	parents := make(map[string]string)
+	for i, timing := range s.Timings() {
+		position[timing.Name] = i
+		parents[timing.Name] = timing.Parent
+	}
+	for _, dep := range [][2]string{{"a", "c"}, {"b", "d"}, {"c", "d"}} {
+		before, after := containers[dep[0]].Name, containers[dep[1]].Name
+		if position[before] >= position[after] {
+			t.Errorf("%s has to be started before %s, got order: %v", before, after, position)
+		}
+	}
+	if parents[containers["d"].Name] != containers["b"].Name {
+		t.Errorf("wrong parent of d, expected %s but got %s", containers["b"].Name, parents[containers["d"].Name])
+	}
+}
+
+func TestSuite_Down(t *testing.T) {
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_Down", testingdock.SuiteOpts{
+		Keep: testingdock.KeepAlways,
+	})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+
+	n := s.Network(testingdock.NetworkOpts{Name: "TestSuite_Down"})
+	n.After(s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Down",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		Volumes: []testingdock.VolumeOpts{{Target: "/data"}},
+	}))
+
+	s.Start(context.TODO())
+	// kept running on close
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s.Down(context.TODO())
+
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	containers, err := testingdock.ListContainers(context.TODO(), cli, "TestSuite_Down")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(containers) != 0 {
+		t.Errorf("expected all containers to be removed, got %d", len(containers))
+	}
+	if _, err = cli.VolumeInspect(context.TODO(), "TestSuite_Down_0"); !client.IsErrVolumeNotFound(err) {
+		t.Errorf("volume should be removed, got: %v", err)
+	}
+}
+
+func TestSuite_Shared(t *testing.T) {
+	child := os.Getenv("TESTINGDOCK_SHARED_CHILD") != ""
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_Shared", testingdock.SuiteOpts{
+		Shared: true,
+	})
+	n := s.Network(testingdock.NetworkOpts{Name: "TestSuite_Shared"})
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Shared",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		StopSignal: "SIGKILL",
+	})
+	n.After(c)
+
+	s.Start(context.TODO())
+	if child {
+		// attached to the container of the parent process
+		fmt.Print("container:" + c.ID + ";")
+		if err := s.Close(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSuite_Shared$")
+	cmd.Env = append(os.Environ(), "TESTINGDOCK_SHARED_CHILD=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("child process failure: %s: %s", err, out)
+	}
+	if !strings.Contains(string(out), "container:"+c.ID+";") {
+		t.Errorf("child process should attach to container %s, got:\n%s", c.ID, out)
+	}
+
+	// the child detached without tearing the suite down
+	cjson, err := c.Inspect(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !cjson.State.Running {
+		t.Error("container should be running")
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = os.Stat(filepath.Join(testingdock.SharedDir, "TestSuite_Shared.json")); !os.IsNotExist(err) {
+		t.Errorf("shared state should be removed by the last process, got: %v", err)
+	}
+}
+
+func TestSuite_Shared_kept(t *testing.T) {
+	child := os.Getenv("TESTINGDOCK_SHARED_CHILD") != ""
+	keep := testingdock.KeepAlways
+	if child {
+		keep = testingdock.KeepNever
+	}
+	s, _ := testingdock.GetOrCreateSuite(t, "TestSuite_Shared_kept", testingdock.SuiteOpts{
+		Shared: true,
+		Keep:   keep,
+	})
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Shared_kept",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		StopSignal: "SIGKILL",
+	})
+	s.Network(testingdock.NetworkOpts{Name: "TestSuite_Shared_kept"}).After(c)
+
+	s.Start(context.TODO())
+	if child {
+		// attached to the container kept by the parent process
+		fmt.Print("container:" + c.ID + ";")
+		if err := s.Close(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return
+	}
+	defer s.Down(context.TODO())
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSuite_Shared_kept$")
+	cmd.Env = append(os.Environ(), "TESTINGDOCK_SHARED_CHILD=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("child process failure: %s: %s", err, out)
+	}
+	if !strings.Contains(string(out), "container:"+c.ID+";") {
+		t.Errorf("child process should attach to the kept container %s, got:\n%s", c.ID, out)
+	}
+
+	// the child did not keep the suite
+	if _, err = c.Inspect(context.TODO()); !client.IsErrContainerNotFound(err) {
+		t.Errorf("container should be removed by the child process, got: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(testingdock.SharedDir, "TestSuite_Shared_kept.json")); !os.IsNotExist(err) {
+		t.Errorf("shared state should be removed by the child process, got: %v", err)
+	}
+}
+
+func TestSuite_Attach(t *testing.T) {
+	// started outside of the tested suite, e.g. by testingdock up -d
+	external, _ := testingdock.GetOrCreateSuite(t, "TestSuite_Attach_external", testingdock.SuiteOpts{
+		Keep: testingdock.KeepAlways,
+	})
+	external.Network(testingdock.NetworkOpts{Name: "TestSuite_Attach"}).After(external.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Attach",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		StopSignal: "SIGKILL",
+	}))
+	external.Start(context.TODO())
+	if err := external.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer external.Down(context.TODO())
+
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_Attach", testingdock.SuiteOpts{
+		Attach: true,
+	})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Attach",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+	})
+	s.Network(testingdock.NetworkOpts{Name: "TestSuite_Attach"}).After(c)
+
+	s.Start(context.TODO())
+	if c.ID != external.ContainerByName("TestSuite_Attach").ID {
+		t.Errorf("container not attached, expected id %s but got %s", external.ContainerByName("TestSuite_Attach").ID, c.ID)
+	}
+	if _, err := c.Endpoints(context.TODO()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	before, err := c.Inspect(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s.Reset(context.TODO())
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cjson, err := c.Inspect(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !cjson.State.Running {
+		t.Error("attached container should still be running after close")
+	}
+	if cjson.State.StartedAt != before.State.StartedAt {
+		t.Errorf("attached container should not be restarted by reset, started at %s and %s", before.State.StartedAt, cjson.State.StartedAt)
+	}
+}
+
+func TestSuite_Attach_crash(t *testing.T) {
+	external, _ := testingdock.GetOrCreateSuite(t, "TestSuite_Attach_crash_external", testingdock.SuiteOpts{
+		Keep: testingdock.KeepAlways,
+	})
+	external.Network(testingdock.NetworkOpts{Name: "TestSuite_Attach_crash"}).After(external.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Attach_crash",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		StopSignal: "SIGKILL",
+	}))
+	external.Start(context.TODO())
+	if err := external.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer external.Down(context.TODO())
+
+	ft := &fatalT{TB: t}
+	s, ok := testingdock.GetOrCreateSuite(ft, "TestSuite_Attach_crash", testingdock.SuiteOpts{
+		Attach: true,
+	})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Attach_crash",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+	})
+	s.Network(testingdock.NetworkOpts{Name: "TestSuite_Attach_crash"}).After(c)
+	s.Start(context.TODO())
+	events := s.Events(context.TODO())
+
+	// the attached container has no labels of the suite
+	cli, err := client.NewEnvClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err = cli.ContainerKill(context.TODO(), c.ID, "SIGKILL"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 50 && s.Err() == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if s.Err() == nil {
+		t.Error("the crash of the attached container should be reported")
+	}
+	select {
+	case e := <-events:
+		if e.Container != "TestSuite_Attach_crash" {
+			t.Errorf("wrong container, expected TestSuite_Attach_crash but got %s", e.Container)
+		}
+	default:
+		t.Error("the events of the attached container should be sent")
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,259 @@
+package testingdock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Phase is a phase of the lifecycle of a container or network.
+type Phase string
+
+const (
+	// PhaseCleanup removes resources left by a previous run
+	PhaseCleanup Phase = "cleanup"
+	// PhasePull pulls the image of a container
+	PhasePull Phase = "pull"
+	// PhaseCreate creates the container or network
+	PhaseCreate Phase = "create"
+	// PhaseStart starts the container, for jobs until they finished
+	PhaseStart Phase = "start"
+	// PhaseFirstProbe is the first call of the health check
+	PhaseFirstProbe Phase = "first-probe"
+	// PhaseHealthy waits until the health check succeeds
+	PhaseHealthy Phase = "healthy"
+	// PhaseReset resets the container, including the health check
+	PhaseReset Phase = "reset"
+	// PhaseStop stops the container gracefully
+	PhaseStop Phase = "stop"
+	// PhaseRemove removes the container or network
+	PhaseRemove Phase = "remove"
+)
+
+// phases are the phases in lifecycle order.
+var phases = []Phase{PhaseCleanup, PhasePull, PhaseCreate, PhaseStart, PhaseFirstProbe, PhaseHealthy, PhaseReset, PhaseStop, PhaseRemove}
+
+// Span is a recorded lifecycle phase.
+type Span struct {
+	Phase Phase     `json:"phase"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration of the phase.
+func (s Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Timing contains the recorded lifecycle phases of a container or network.
+type Timing struct {
+	Name string `json:"name"`
+	// Kind is either container or network
+	Kind string `json:"kind"`
+	// Parent is the name of the container or network it was started after
+	Parent string `json:"parent,omitempty"`
+	Spans  []Span `json:"spans"`
+	// Critical is set if it is on the critical path of the start, the
+	// chain of dependencies, which took the longest to become ready
+	Critical bool `json:"critical"`
+}
+
+// Total returns the summed up duration of all spans of the phase, e.g. of
+// all resets.
+func (t Timing) Total(phase Phase) time.Duration {
+	var d time.Duration
+	for _, s := range t.Spans {
+		if s.Phase == phase {
+			d += s.Duration()
+		}
+	}
+	return d
+}
+
+// ready returns the time the container became ready on start, which is
+// the end of its first health check or, for jobs, its first run.
+func (t Timing) ready() time.Time {
+	var started time.Time
+	for _, s := range t.Spans {
+		switch s.Phase {
+		case PhaseHealthy:
+			return s.End
+		case PhaseStart:
+			if started.IsZero() {
+				started = s.End
+			}
+		}
+	}
+	return started
+}
+
+// timeline records the lifecycle phases of a container or network.
+type timeline struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+// begin starts recording a phase, the returned function ends it and
+// returns the recorded span.
+func (tl *timeline) begin(phase Phase) func() Span {
+	start := time.Now()
+	return func() Span {
+		span := Span{Phase: phase, Start: start, End: time.Now()}
+		tl.mu.Lock()
+		tl.spans = append(tl.spans, span)
+		tl.mu.Unlock()
+		return span
+	}
+}
+
+// get returns a copy of the recorded spans.
+func (tl *timeline) get() []Span {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return append([]Span(nil), tl.spans...)
+}
+
+// phase starts recording a phase of the container, the returned function
+// ends it and passes it to the tracers of the suite.
+func (c *Container) phase(phase Phase) func() {
+	end := c.timeline.begin(phase)
+	return func() {
+		span := end()
+		if c.suite != nil {
+			c.suite.trace(TraceSpan{
+				Name:  "container." + string(span.Phase),
+				Start: span.Start,
+				End:   span.End,
+				Attributes: map[string]string{
+					"suite":           c.suite.name,
+					"container.name":  c.Name,
+					"container.image": c.image,
+					"container.id":    c.ID,
+				},
+			})
+		}
+	}
+}
+
+// phase starts recording a phase of the network, the returned function
+// ends it and passes it to the tracers of the suite.
+func (n *Network) phase(phase Phase) func() {
+	end := n.timeline.begin(phase)
+	return func() {
+		span := end()
+		if n.suite != nil {
+			n.suite.trace(TraceSpan{
+				Name:  "network." + string(span.Phase),
+				Start: span.Start,
+				End:   span.End,
+				Attributes: map[string]string{
+					"suite":        n.suite.name,
+					"network.name": n.name,
+					"network.id":   n.id,
+				},
+			})
+		}
+	}
+}
+
+// Timings returns the recorded lifecycle phases of the network and all
+// containers, parents first.
+func (s *Suite) Timings() []Timing {
+	if s.network == nil {
+		return nil
+	}
+
+	timings := []Timing{{Name: s.network.name, Kind: "network", Spans: s.network.timeline.get()}}
+	index := make(map[*Container]int)
+	parents := make(map[*Container]*Container)
+	var containers []*Container
+	var walk func(parent *Container, children []*Container)
+	walk = func(parent *Container, children []*Container) {
+		name := s.network.name
+		if parent != nil {
+			name = parent.Name
+		}
+		for _, c := range children {
+			index[c] = len(timings)
+			parents[c] = parent
+			containers = append(containers, c)
+			timings = append(timings, Timing{Name: c.Name, Kind: "container", Parent: name, Spans: c.timeline.get()})
+			walk(c, c.children)
+		}
+	}
+	walk(nil, s.network.children)
+	ready := func(c *Container) time.Time { return timings[index[c]].ready() }
+
+	// the critical path ends with the container, which became ready last,
+	// and leads back through the dependencies, which blocked its start:
+	// the parent or a waited for container, whichever became ready last
+	timings[0].Critical = true
+	var crit *Container
+	for _, c := range containers {
+		if crit == nil || ready(c).After(ready(crit)) {
+			crit = c
+		}
+	}
+	for crit != nil {
+		timings[index[crit]].Critical = true
+		blocker := parents[crit]
+		for _, cc := range crit.waitFor {
+			if _, ok := index[cc]; ok && (blocker == nil || ready(cc).After(ready(blocker))) {
+				blocker = cc
+			}
+		}
+		crit = blocker
+	}
+	return timings
+}
+
+// printTimings prints a table of the time spent in each phase, containers
+// on the critical path are marked with an asterisk.
+func (s *Suite) printTimings() {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := []string{"", "name"}
+	for _, p := range phases {
+		header = append(header, string(p))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t") // nolint: errcheck
+	for _, t := range s.Timings() {
+		row := []string{"", t.Name}
+		if t.Critical {
+			row[0] = "*"
+		}
+		for _, p := range phases {
+			row = append(row, t.Total(p).Round(time.Millisecond).String())
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t")+"\t") // nolint: errcheck
+	}
+	w.Flush() // nolint: errcheck
+
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		printf("(timing) %s", line)
+	}
+}
+
+// writeTimings writes the timings as JSON to <dir>/<suite name>.json.
+func (s *Suite) writeTimings(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s.Timings(), "", "  ")
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, s.name+".json")
+	if err = ioutil.WriteFile(name, data, 0644); err != nil {
+		return err
+	}
+	printf("(timing) %-25s - timings written to: %s", s.name, name)
+	return nil
+}
@@ -0,0 +1,155 @@
+package testingdock
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TraceSpan is a finished operation in the lifecycle of a suite, e.g. the
+// pull of a container image.
+type TraceSpan struct {
+	Name       string
+	Start, End time.Time
+	// Attributes describe the container or network of the operation, e.g.
+	// container.name, container.image and container.id
+	Attributes map[string]string
+}
+
+// Tracer receives the spans of a suite, e.g. to export them as OpenTelemetry
+// spans with explicit start and end timestamps. It has to be safe for
+// concurrent use, spans of containers started in parallel are traced in
+// parallel.
+type Tracer interface {
+	Trace(span TraceSpan)
+}
+
+// TracerFunc is an adapter to use an ordinary function as Tracer.
+type TracerFunc func(span TraceSpan)
+
+// Trace implements Tracer interface.
+func (fn TracerFunc) Trace(span TraceSpan) {
+	fn(span)
+}
+
+// trace passes the span to all tracers of the suite.
+func (s *Suite) trace(span TraceSpan) {
+	for _, t := range s.tracers {
+		t.Trace(span)
+	}
+}
+
+// ChromeTrace is a Tracer, which collects the spans in the Chrome trace event
+// format, which can be opened in chrome://tracing or Perfetto. Every
+// container and network gets its own track, so parallel starts are visible.
+type ChromeTrace struct {
+	mu     sync.Mutex
+	spans  []TraceSpan
+	tracks map[string]int
+}
+
+// NewChromeTrace creates an empty Chrome trace.
+func NewChromeTrace() *ChromeTrace {
+	return &ChromeTrace{tracks: make(map[string]int)}
+}
+
+// Trace implements Tracer interface.
+func (t *ChromeTrace) Trace(span TraceSpan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.spans = append(t.spans, span)
+	track := trackName(span)
+	if _, ok := t.tracks[track]; !ok {
+		t.tracks[track] = len(t.tracks) + 1
+	}
+}
+
+// chromeEvent is an event of the Chrome trace event format.
+type chromeEvent struct {
+	Name      string            `json:"name"`
+	Category  string            `json:"cat,omitempty"`
+	Phase     string            `json:"ph"`
+	Timestamp int64             `json:"ts"`
+	Duration  int64             `json:"dur,omitempty"`
+	PID       int               `json:"pid"`
+	TID       int               `json:"tid"`
+	Args      map[string]string `json:"args,omitempty"`
+}
+
+// WriteTo writes the trace as JSON, it implements io.WriterTo interface.
+func (t *ChromeTrace) WriteTo(w io.Writer) (int64, error) {
+	t.mu.Lock()
+	var events, metadata []chromeEvent
+	for track, tid := range t.tracks {
+		metadata = append(metadata, chromeEvent{
+			Name:  "thread_name",
+			Phase: "M",
+			PID:   1,
+			TID:   tid,
+			Args:  map[string]string{"name": track},
+		})
+	}
+	for _, span := range t.spans {
+		events = append(events, chromeEvent{
+			Name:      span.Name,
+			Category:  "testingdock",
+			Phase:     "X",
+			Timestamp: span.Start.UnixNano() / int64(time.Microsecond),
+			Duration:  int64(span.End.Sub(span.Start) / time.Microsecond),
+			PID:       1,
+			TID:       t.tracks[trackName(span)],
+			Args:      span.Attributes,
+		})
+	}
+	t.mu.Unlock()
+
+	// tracks in order of appearance, followed by the spans in order of start
+	sort.Slice(metadata, func(i, j int) bool { return metadata[i].TID < metadata[j].TID })
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp < events[j].Timestamp })
+	events = append(metadata, events...)
+
+	data, err := json.Marshal(struct {
+		TraceEvents     []chromeEvent `json:"traceEvents"`
+		DisplayTimeUnit string        `json:"displayTimeUnit"`
+	}{
+		TraceEvents:     events,
+		DisplayTimeUnit: "ms",
+	})
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// WriteFile writes the trace as JSON to the file.
+func (t *ChromeTrace) WriteFile(name string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err = t.WriteTo(f); err != nil {
+		f.Close() // nolint: errcheck
+		return err
+	}
+	return f.Close()
+}
+
+// trackName returns the name of the container, network or suite the span
+// belongs to.
+func trackName(span TraceSpan) string {
+	for _, key := range []string{"container.name", "network.name", "suite"} {
+		if name, ok := span.Attributes[key]; ok {
+			return name
+		}
+	}
+	return span.Name
+}
@@ -0,0 +1,109 @@
+package testingdock_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/piotrkowalczuk/testingdock"
+)
+
+func TestChromeTrace(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	trace := testingdock.NewChromeTrace()
+	trace.Trace(testingdock.TraceSpan{
+		Name:       "container.pull",
+		Start:      start.Add(time.Second),
+		End:        start.Add(3 * time.Second),
+		Attributes: map[string]string{"container.name": "postgres"},
+	})
+	trace.Trace(testingdock.TraceSpan{
+		Name:       "network.create",
+		Start:      start,
+		End:        start.Add(time.Millisecond),
+		Attributes: map[string]string{"network.name": "net"},
+	})
+
+	var buf bytes.Buffer
+	if _, err := trace.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var got struct {
+		TraceEvents []struct {
+			Name  string            `json:"name"`
+			Phase string            `json:"ph"`
+			TS    int64             `json:"ts"`
+			Dur   int64             `json:"dur"`
+			TID   int               `json:"tid"`
+			Args  map[string]string `json:"args"`
+		} `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(got.TraceEvents) != 4 {
+		t.Fatalf("wrong number of events: %d", len(got.TraceEvents))
+	}
+
+	tracks := make(map[int]string)
+	for _, e := range got.TraceEvents[:2] {
+		if e.Phase != "M" {
+			t.Fatalf("expected track metadata first, got: %s", e.Phase)
+		}
+		tracks[e.TID] = e.Args["name"]
+	}
+	create, pull := got.TraceEvents[2], got.TraceEvents[3]
+	if create.Name != "network.create" || tracks[create.TID] != "net" {
+		t.Errorf("wrong first span: %s on track %s", create.Name, tracks[create.TID])
+	}
+	if pull.Name != "container.pull" || tracks[pull.TID] != "postgres" {
+		t.Errorf("wrong second span: %s on track %s", pull.Name, tracks[pull.TID])
+	}
+	if pull.TS != 1500000001000000 || pull.Dur != 2000000 {
+		t.Errorf("wrong timestamp or duration: %d %d", pull.TS, pull.Dur)
+	}
+}
+
+func TestSuite_Tracer(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		spans = make(map[string]testingdock.TraceSpan)
+	)
+	s, ok := testingdock.GetOrCreateSuite(t, "TestSuite_Tracer", testingdock.SuiteOpts{
+		Tracer: testingdock.TracerFunc(func(span testingdock.TraceSpan) {
+			mu.Lock()
+			spans[span.Name] = span
+			mu.Unlock()
+		}),
+	})
+	if ok {
+		t.Fatal("this suite should not exists yet")
+	}
+	c := s.Container(testingdock.ContainerOpts{
+		Name: "TestSuite_Tracer",
+		Config: &container.Config{
+			Image: "alpine:3.6",
+			Cmd:   []string{"sleep", "60"},
+		},
+		StopSignal: "SIGKILL",
+	})
+	s.Network(testingdock.NetworkOpts{Name: "TestSuite_Tracer"}).After(c)
+
+	s.Start(context.TODO())
+	defer s.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the spans creating the resources carry their IDs
+	if id := spans["container.create"].Attributes["container.id"]; id == "" || id != c.ID {
+		t.Errorf("wrong container id of the create span, expected %s but got %q", c.ID, id)
+	}
+	if id := spans["network.create"].Attributes["network.id"]; id == "" {
+		t.Error("network id of the create span missing")
+	}
+}
@@ -0,0 +1,181 @@
+package testingdock
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// VolumeOpts describes a named volume managed by testingdock. The volume is
+// created with the owner=testingdock label before the container, so it does
+// not survive a crashed test run, and removed when the container is closed.
+type VolumeOpts struct {
+	// Name of the volume, the default is <container name>_<index>
+	Name string
+	// Target is the path the volume is mounted at inside the container
+	Target   string
+	ReadOnly bool
+	// HostDir is an optional directory, which content is copied into the
+	// volume after it is created
+	HostDir string
+	// Tar is an optional path of a tar archive, which is extracted into the
+	// volume after it is created, it may be compressed with gzip, bzip2 or xz
+	Tar string
+}
+
+// volumeBinds adds the volumes to the binds of the host configuration and
+// the tmpfs mounts to its tmpfs, so they are part of the configuration hash.
+func volumeBinds(name string, volumes []VolumeOpts, tmpfs []string, hcfg *container.HostConfig) []VolumeOpts {
+	res := make([]VolumeOpts, 0, len(volumes))
+	for i, v := range volumes {
+		if v.Name == "" {
+			v.Name = fmt.Sprintf("%s_%d", name, i)
+		}
+		bind := v.Name + ":" + v.Target
+		if v.ReadOnly {
+			bind += ":ro"
+		}
+		hcfg.Binds = append(hcfg.Binds, bind)
+		res = append(res, v)
+	}
+	if len(tmpfs) > 0 && hcfg.Tmpfs == nil {
+		hcfg.Tmpfs = make(map[string]string, len(tmpfs))
+	}
+	for _, dir := range tmpfs {
+		hcfg.Tmpfs[dir] = ""
+	}
+	return res
+}
+
+// createVolumes removes volumes left by a previous run and creates the
+// volumes of the container.
+func (c *Container) createVolumes(ctx context.Context) error {
+	for _, v := range c.volumes {
+		if err := removeVolume(ctx, c.cli, v.Name); err != nil {
+			return err
+		}
+
+		labels := createTestingLabel()
+		labels[SuiteLabel] = c.suite.name
+		if _, err := c.cli.VolumeCreate(ctx, volumetypes.VolumesCreateBody{
+			Name:   v.Name,
+			Labels: labels,
+		}); err != nil {
+			return err
+		}
+		printf("(setup ) %-25s (%s) - volume created: %s", c.Name, c.ID, v.Name)
+	}
+	return nil
+}
+
+// initVolumes copies the initial content into the volumes of the created
+// container.
+func (c *Container) initVolumes(ctx context.Context) error {
+	for _, v := range c.volumes {
+		var (
+			content io.Reader
+			err     error
+		)
+		switch {
+		case v.HostDir != "":
+			content, err = tarDir(v.HostDir)
+		case v.Tar != "":
+			var f *os.File
+			if f, err = os.Open(v.Tar); err == nil {
+				defer f.Close() // nolint: errcheck
+				content = f
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = c.cli.CopyToContainer(ctx, c.ID, v.Target, content, types.CopyToContainerOptions{}); err != nil {
+			return fmt.Errorf("volume %s initialization failure: %s", v.Name, err)
+		}
+		printf("(setup ) %-25s (%s) - volume initialized: %s", c.Name, c.ID, v.Name)
+	}
+	return nil
+}
+
+// removeVolumes removes the volumes of the container, it has to be removed first.
+func (c *Container) removeVolumes(ctx context.Context) error {
+	for _, v := range c.volumes {
+		if err := c.cli.VolumeRemove(ctx, v.Name, true); err != nil {
+			return err
+		}
+		printf("(cancel) %-25s (%s) - volume removed: %s", c.Name, c.ID, v.Name)
+	}
+	return nil
+}
+
+// removeVolume removes the volume with the given name, if it exists and was
+// created by testingdock.
+func removeVolume(ctx context.Context, cli *client.Client, name string) error {
+	vol, err := cli.VolumeInspect(ctx, name)
+	if client.IsErrVolumeNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !isOwnedByTestingdock(vol.Labels) {
+		return fmt.Errorf("volume with name %s already exists, but wasn't created by testingdock", name)
+	}
+	if err = cli.VolumeRemove(ctx, name, true); err != nil {
+		return err
+	}
+	printf("(setup ) %-25s (%-64s) - volume removed", name, "")
+	return nil
+}
+
+// tarDir creates a tar archive of the directory content, relative to it.
+func tarDir(dir string) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close() // nolint: errcheck
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}